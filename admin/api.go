@@ -0,0 +1,157 @@
+// Copyright (c) 2017 off-sync
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/off-sync/platform-proxy-aws/services"
+)
+
+// Repository is the service repository inspected and refreshed by the admin
+// API. It is implemented by services.ServiceRepository.
+type Repository interface {
+	Snapshot() *services.Snapshot
+	RefreshWithContext(ctx context.Context) error
+	RefreshServiceWithContext(ctx context.Context, name string) (*services.ServiceState, error)
+}
+
+// errorResponse is the JSON response of a failed API request.
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// WithAPI configures a server with the admin API on the provided repository:
+//
+//	GET  /api/services                 the state of all services
+//	GET  /api/services?name=<name>     the state of a single service
+//	POST /api/refresh                  refresh all services
+//	POST /api/refresh?service=<name>   refresh a single service
+//
+// Requests must provide the token as a bearer token. Refreshes are canceled
+// when the request is, and a refresh of all services is rejected while another
+// one is running.
+func WithAPI(repository Repository, token string) ServerOption {
+	return func(s *Server) error {
+		if token == "" {
+			return errors.New("no admin API token provided")
+		}
+
+		api := &api{repository: repository, token: token}
+
+		s.mux.Handle("/api/services", api.authorized(http.MethodGet, api.services))
+		s.mux.Handle("/api/refresh", api.authorized(http.MethodPost, api.refresh))
+
+		return nil
+	}
+}
+
+type api struct {
+	repository Repository
+	token      string
+
+	// refreshing is held while all services are refreshed
+	refreshing sync.Mutex
+}
+
+// bearerPrefix is the prefix of the authorization header of a bearer token.
+const bearerPrefix = "Bearer "
+
+// authorized only passes requests with the provided method and token to the
+// handler.
+func (a *api) authorized(method string, handler http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := r.Header.Get("Authorization")
+
+		valid := strings.HasPrefix(authorization, bearerPrefix) &&
+			subtle.ConstantTimeCompare([]byte(authorization[len(bearerPrefix):]), []byte(a.token)) == 1
+		if !valid {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSON(w, http.StatusUnauthorized, &errorResponse{Error: "invalid token"})
+			return
+		}
+
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeJSON(w, http.StatusMethodNotAllowed, &errorResponse{Error: "method not allowed"})
+			return
+		}
+
+		handler(w, r)
+	})
+}
+
+func (a *api) services(w http.ResponseWriter, r *http.Request) {
+	snapshot := a.repository.Snapshot()
+
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		writeJSON(w, http.StatusOK, snapshot)
+		return
+	}
+
+	for _, state := range snapshot.Services {
+		if state.Name == name {
+			writeJSON(w, http.StatusOK, state)
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusNotFound, &errorResponse{Error: "unknown service: " + name})
+}
+
+func (a *api) refresh(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("service")
+	if name == "" {
+		if !a.refreshing.TryLock() {
+			writeJSON(w, http.StatusConflict, &errorResponse{Error: "refresh already running"})
+			return
+		}
+		defer a.refreshing.Unlock()
+
+		err := a.repository.RefreshWithContext(r.Context())
+		if err != nil {
+			writeJSON(w, http.StatusBadGateway, &errorResponse{Error: err.Error()})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, a.repository.Snapshot())
+		return
+	}
+
+	state, err := a.repository.RefreshServiceWithContext(r.Context(), name)
+	if state == nil {
+		writeJSON(w, http.StatusNotFound, &errorResponse{Error: "unknown service: " + name})
+		return
+	}
+
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, state)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, state)
+}
@@ -0,0 +1,156 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/off-sync/platform-proxy-aws/interfaces"
+	"github.com/off-sync/platform-proxy-aws/services"
+)
+
+const token = "secret"
+
+func setUpAPI(t *testing.T) (*Server, *interfaces.AwsEcsAPIMock) {
+	api := interfaces.NewAwsEcsAPIMock()
+	api.ServiceNames = []string{"web"}
+	api.Services["web"] = &ecs.Service{
+		ServiceName:    aws.String("web"),
+		TaskDefinition: aws.String("webTaskDef"),
+	}
+	api.TaskDefs["webTaskDef"] = &ecs.TaskDefinition{
+		ContainerDefinitions: []*ecs.ContainerDefinition{
+			&ecs.ContainerDefinition{
+				Name:     aws.String(services.DefaultServerContainerName),
+				Hostname: aws.String("web"),
+			},
+		},
+	}
+
+	r, err := services.NewServiceRepository(api)
+	assert.Nil(t, err)
+
+	s, err := NewServer(WithAPI(r, token))
+	assert.Nil(t, err)
+
+	return s, api
+}
+
+func do(s *Server, method, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestAPIShouldRequireToken(t *testing.T) {
+	s, _ := setUpAPI(t)
+
+	assert.Equal(t, http.StatusUnauthorized, do(s, http.MethodGet, "/api/services", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, do(s, http.MethodPost, "/api/refresh", "wrong").Code)
+
+	// the token must be a bearer token
+	req := httptest.NewRequest(http.MethodGet, "/api/services", nil)
+	req.Header.Set("Authorization", token)
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	assert.Equal(t, http.StatusMethodNotAllowed, do(s, http.MethodGet, "/api/refresh", token).Code)
+
+	_, err := NewServer(WithAPI(nil, ""))
+	assert.NotNil(t, err)
+}
+
+func TestAPIRefreshAndList(t *testing.T) {
+	s, _ := setUpAPI(t)
+
+	rec := do(s, http.MethodGet, "/api/services", token)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var snapshot services.Snapshot
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &snapshot))
+	assert.True(t, snapshot.Discovered.IsZero())
+	assert.Len(t, snapshot.Services, 0)
+
+	rec = do(s, http.MethodPost, "/api/refresh", token)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = do(s, http.MethodGet, "/api/services?name=web", token)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var state services.ServiceState
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &state))
+	assert.Equal(t, "web", state.Name)
+	if assert.NotNil(t, state.Description) && assert.Len(t, state.Description.ServerSets, 1) {
+		assert.Equal(t, "http://web:8080", state.Description.ServerSets[0].Servers[0].URL.String())
+	}
+
+	assert.Equal(t, http.StatusNotFound, do(s, http.MethodGet, "/api/services?name=api", token).Code)
+}
+
+func TestAPIRefreshService(t *testing.T) {
+	s, api := setUpAPI(t)
+
+	assert.Equal(t, http.StatusOK, do(s, http.MethodPost, "/api/refresh?service=web", token).Code)
+	assert.Equal(t, http.StatusNotFound, do(s, http.MethodPost, "/api/refresh?service=api", token).Code)
+
+	api.FailDescribeService = true
+	assert.Equal(t, http.StatusBadGateway, do(s, http.MethodPost, "/api/refresh?service=web", token).Code)
+
+	api.FailListServices = true
+	assert.Equal(t, http.StatusBadGateway, do(s, http.MethodPost, "/api/refresh", token).Code)
+}
+
+// blockingRepository blocks refreshing all services until released.
+type blockingRepository struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (r *blockingRepository) Snapshot() *services.Snapshot {
+	return &services.Snapshot{}
+}
+
+func (r *blockingRepository) RefreshWithContext(ctx context.Context) error {
+	r.started <- struct{}{}
+	<-r.release
+
+	return nil
+}
+
+func (r *blockingRepository) RefreshServiceWithContext(ctx context.Context, name string) (*services.ServiceState, error) {
+	return &services.ServiceState{Name: name}, nil
+}
+
+func TestAPIShouldRejectOverlappingRefreshes(t *testing.T) {
+	r := &blockingRepository{started: make(chan struct{}), release: make(chan struct{})}
+
+	s, err := NewServer(WithAPI(r, token))
+	assert.Nil(t, err)
+
+	done := make(chan int)
+	go func() {
+		done <- do(s, http.MethodPost, "/api/refresh", token).Code
+	}()
+
+	<-r.started
+
+	assert.Equal(t, http.StatusConflict, do(s, http.MethodPost, "/api/refresh", token).Code)
+	assert.Equal(t, http.StatusOK, do(s, http.MethodPost, "/api/refresh?service=web", token).Code)
+
+	close(r.release)
+	assert.Equal(t, http.StatusOK, <-done)
+}
@@ -0,0 +1,284 @@
+// Copyright (c) 2017 off-sync
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package admin implements the admin HTTP server of the proxy, exposing the
+// health and readiness of the proxy process and, optionally, an API to
+// inspect and refresh the discovered services.
+package admin
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Check checks a single aspect of the readiness of the proxy using the context
+// of the readiness request. It returns an error if the proxy is not ready.
+type Check func(ctx context.Context) error
+
+// Check statuses.
+const (
+	StatusOK       = "ok"
+	StatusFailed   = "failed"
+	StatusReady    = "ready"
+	StatusNotReady = "not ready"
+)
+
+// ErrNoDiscovery is returned by the discovery check if services have not been
+// discovered successfully yet.
+var ErrNoDiscovery = errors.New("no successful discovery yet")
+
+// ErrNoSystemCertificates is returned by the certificates check if no system
+// certificates are found.
+var ErrNoSystemCertificates = errors.New("no system certificates found")
+
+// systemCertPool returns the system certificate pool, replaced in tests.
+var systemCertPool = x509.SystemCertPool
+
+// Server is the admin HTTP server. It exposes /healthz, which reports that
+// the process is alive, and /readyz, which runs the readiness checks.
+type Server struct {
+	mux    *http.ServeMux
+	checks []*namedCheck
+}
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// ServerOption defines the type used to further configure a Server.
+type ServerOption func(*Server) error
+
+// NewServer creates a new admin server.
+func NewServer(options ...ServerOption) (*Server, error) {
+	s := &Server{
+		mux: http.NewServeMux(),
+	}
+
+	for _, opt := range options {
+		err := opt(s)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s.mux.HandleFunc("/healthz", s.healthz)
+	s.mux.HandleFunc("/readyz", s.readyz)
+
+	return s, nil
+}
+
+// WithReadinessCheck configures a server with the provided readiness check.
+// Checks are run in the order they are configured.
+func WithReadinessCheck(name string, check Check) ServerOption {
+	return func(s *Server) error {
+		for _, c := range s.checks {
+			if c.name == name {
+				return fmt.Errorf("duplicate readiness check: %s", name)
+			}
+		}
+
+		s.checks = append(s.checks, &namedCheck{name: name, check: check})
+		return nil
+	}
+}
+
+// Discoverer is implemented by services.ServiceRepository.
+type Discoverer interface {
+	LastDiscovery() time.Time
+}
+
+// DiscoveryCheck returns a check that fails until services have been
+// discovered successfully at least once.
+func DiscoveryCheck(d Discoverer) Check {
+	return func(ctx context.Context) error {
+		if d.LastDiscovery().IsZero() {
+			return ErrNoDiscovery
+		}
+
+		return nil
+	}
+}
+
+// CertificatesCheck returns a check that fails unless every file contains at
+// least one PEM encoded certificate. Without files, the check fails unless the
+// system certificate pool can be loaded and is not empty. A pool verified by
+// the platform, as on macOS and Windows, is never empty.
+func CertificatesCheck(files ...string) Check {
+	return func(ctx context.Context) error {
+		if len(files) == 0 {
+			pool, err := systemCertPool()
+			if err != nil {
+				return fmt.Errorf("loading system certificates: %s", err)
+			}
+
+			if pool.Equal(x509.NewCertPool()) {
+				return ErrNoSystemCertificates
+			}
+
+			return nil
+		}
+
+		for _, file := range files {
+			pem, err := ioutil.ReadFile(file)
+			if err != nil {
+				return fmt.Errorf("loading certificates: %s", err)
+			}
+
+			if !x509.NewCertPool().AppendCertsFromPEM(pem) {
+				return fmt.Errorf("no certificates found in %s", file)
+			}
+		}
+
+		return nil
+	}
+}
+
+// CachedCheck returns a check that runs the provided check at most once per
+// ttl and otherwise returns its last result, e.g. to avoid calling an API on
+// every readiness probe.
+//
+// The check runs in the background with the provided timeout, so that a slow
+// check does not block the readiness requests: while it runs, its last result
+// is returned. Only the first requests wait for the check, or until their
+// context is done. The check is not canceled with the request that started it,
+// as its result is shared.
+func CachedCheck(check Check, ttl, timeout time.Duration) Check {
+	var (
+		mutex   sync.Mutex
+		checked time.Time
+		result  error
+		running chan struct{}
+	)
+
+	return func(ctx context.Context) error {
+		mutex.Lock()
+
+		if running == nil && (checked.IsZero() || time.Since(checked) >= ttl) {
+			done := make(chan struct{})
+			running = done
+
+			go func() {
+				defer close(done)
+
+				checkCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+				defer cancel()
+
+				err := check(checkCtx)
+
+				mutex.Lock()
+				defer mutex.Unlock()
+
+				checked = time.Now()
+				result = err
+				running = nil
+			}()
+		}
+
+		lastResult, wait := result, running
+		if !checked.IsZero() {
+			// do not wait for a check that runs again
+			wait = nil
+		}
+
+		mutex.Unlock()
+
+		if wait == nil {
+			return lastResult
+		}
+
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		return result
+	}
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// healthResponse is the JSON response of /healthz.
+type healthResponse struct {
+	Status string `json:"status"`
+}
+
+// readinessResponse is the JSON response of /readyz.
+type readinessResponse struct {
+	Status string         `json:"status"`
+	Checks []*checkResult `json:"checks"`
+}
+
+type checkResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, &healthResponse{Status: StatusOK})
+}
+
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	resp := &readinessResponse{
+		Status: StatusReady,
+		Checks: make([]*checkResult, 0, len(s.checks)),
+	}
+
+	code := http.StatusOK
+
+	for _, c := range s.checks {
+		result := &checkResult{Name: c.name, Status: StatusOK}
+
+		err := c.check(r.Context())
+		if err != nil {
+			result.Status = StatusFailed
+			result.Error = err.Error()
+
+			resp.Status = StatusNotReady
+			code = http.StatusServiceUnavailable
+		}
+
+		resp.Checks = append(resp.Checks, result)
+	}
+
+	writeJSON(w, code, resp)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	json.NewEncoder(w).Encode(v)
+}
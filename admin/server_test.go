@@ -0,0 +1,185 @@
+package admin
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type discoverer time.Time
+
+func (d discoverer) LastDiscovery() time.Time {
+	return time.Time(d)
+}
+
+func get(t *testing.T, s *Server, path string, v interface{}) int {
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), v))
+
+	return rec.Code
+}
+
+func TestHealthz(t *testing.T) {
+	s, err := NewServer(WithReadinessCheck("failing", func(ctx context.Context) error {
+		return errors.New("failed")
+	}))
+	assert.Nil(t, err)
+
+	var resp healthResponse
+	assert.Equal(t, http.StatusOK, get(t, s, "/healthz", &resp))
+	assert.Equal(t, StatusOK, resp.Status)
+}
+
+func TestReadyz(t *testing.T) {
+	s, err := NewServer(
+		WithReadinessCheck("cluster", func(ctx context.Context) error { return nil }),
+		WithReadinessCheck("discovery", DiscoveryCheck(discoverer(time.Time{}))))
+	assert.Nil(t, err)
+
+	var resp readinessResponse
+	assert.Equal(t, http.StatusServiceUnavailable, get(t, s, "/readyz", &resp))
+	assert.EqualValues(t, readinessResponse{
+		Status: StatusNotReady,
+		Checks: []*checkResult{
+			&checkResult{Name: "cluster", Status: StatusOK},
+			&checkResult{Name: "discovery", Status: StatusFailed, Error: ErrNoDiscovery.Error()},
+		},
+	}, resp)
+
+	s, err = NewServer(WithReadinessCheck("discovery", DiscoveryCheck(discoverer(time.Now()))))
+	assert.Nil(t, err)
+
+	resp = readinessResponse{}
+	assert.Equal(t, http.StatusOK, get(t, s, "/readyz", &resp))
+	assert.Equal(t, StatusReady, resp.Status)
+}
+
+func TestCertificatesCheck(t *testing.T) {
+	ts := httptest.NewTLSServer(http.NotFoundHandler())
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "admin")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	valid := filepath.Join(dir, "ca.pem")
+	assert.Nil(t, ioutil.WriteFile(valid, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: ts.Certificate().Raw,
+	}), 0644))
+
+	invalid := filepath.Join(dir, "invalid.pem")
+	assert.Nil(t, ioutil.WriteFile(invalid, []byte("not a certificate"), 0644))
+
+	ctx := context.Background()
+
+	assert.Nil(t, CertificatesCheck(valid)(ctx))
+	assert.NotNil(t, CertificatesCheck(valid, invalid)(ctx))
+	assert.NotNil(t, CertificatesCheck(filepath.Join(dir, "missing.pem"))(ctx))
+}
+
+func TestCertificatesCheckShouldFailWithoutSystemCertificates(t *testing.T) {
+	defer func() { systemCertPool = x509.SystemCertPool }()
+
+	systemCertPool = func() (*x509.CertPool, error) {
+		return x509.NewCertPool(), nil
+	}
+
+	assert.Equal(t, ErrNoSystemCertificates, CertificatesCheck()(context.Background()))
+}
+
+func TestCachedCheck(t *testing.T) {
+	var calls int32
+
+	check := CachedCheck(func(ctx context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		return errors.New("failed")
+	}, 20*time.Millisecond, time.Second)
+
+	ctx := context.Background()
+
+	assert.NotNil(t, check(ctx))
+	assert.NotNil(t, check(ctx))
+	assert.EqualValues(t, 1, atomic.LoadInt32(&calls))
+
+	time.Sleep(20 * time.Millisecond)
+
+	// the check runs again in the background
+	assert.NotNil(t, check(ctx))
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&calls) == 2
+	}, time.Second, time.Millisecond)
+}
+
+func TestCachedCheckShouldNotBlockOnSlowCheck(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	var calls int32
+
+	check := CachedCheck(func(ctx context.Context) error {
+		if atomic.AddInt32(&calls, 1) > 1 {
+			<-release
+		}
+
+		return nil
+	}, 10*time.Millisecond, time.Hour)
+
+	assert.Nil(t, check(context.Background()))
+
+	time.Sleep(10 * time.Millisecond)
+
+	// the last result is returned while the check runs again
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	assert.Nil(t, check(ctx))
+	assert.Nil(t, check(ctx))
+	assert.Nil(t, ctx.Err())
+}
+
+func TestCachedCheckShouldWaitForFirstResultUntilContextIsDone(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	check := CachedCheck(func(ctx context.Context) error {
+		<-release
+		return nil
+	}, time.Hour, time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	assert.Equal(t, context.DeadlineExceeded, check(ctx))
+}
+
+func TestCachedCheckShouldTimeOut(t *testing.T) {
+	check := CachedCheck(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, time.Hour, 10*time.Millisecond)
+
+	assert.Equal(t, context.DeadlineExceeded, check(context.Background()))
+}
+
+func TestDuplicateReadinessCheck(t *testing.T) {
+	check := func(ctx context.Context) error { return nil }
+
+	_, err := NewServer(WithReadinessCheck("cluster", check), WithReadinessCheck("cluster", check))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,39 @@
+package atomicfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "atomicfile")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "file.json")
+
+	assert.Nil(t, WriteFile(file, []byte("first"), 0644))
+	assert.Nil(t, WriteFile(file, []byte("second"), 0600))
+
+	data, err := ioutil.ReadFile(file)
+	assert.Nil(t, err)
+	assert.Equal(t, "second", string(data))
+
+	info, err := os.Stat(file)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// no temporary files are left behind
+	files, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestWriteFileShouldFailInMissingDirectory(t *testing.T) {
+	err := WriteFile(filepath.Join("missing", "dir", "file.json"), []byte("data"), 0644)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2017 off-sync
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/off-sync/platform-proxy-aws/admin"
+	"github.com/off-sync/platform-proxy-aws/infra"
+	"github.com/off-sync/platform-proxy-aws/services"
+)
+
+// Configuration keys.
+const (
+	adminAddress = "adminAddress"
+	adminToken   = "adminToken"
+
+	certificateFiles = "certificateFiles"
+)
+
+// clusterCheckTTL is the time for which the result of the cluster readiness
+// check is reused, so that readiness probes do not call the ECS API directly.
+const clusterCheckTTL = 30 * time.Second
+
+// clusterCheckTimeout is the timeout of describing the cluster in the cluster
+// readiness check.
+const clusterCheckTimeout = 10 * time.Second
+
+// newAdminServer creates the admin server of the proxy. It is ready when the
+// cluster can be reached, services have been discovered at least once and
+// the certificates can be loaded. The admin API is only enabled if an admin
+// token is configured.
+func newAdminServer(sdk *infra.AwsEcsSdk, serviceRepository *services.ServiceRepository) (*admin.Server, error) {
+	options := []admin.ServerOption{
+		admin.WithReadinessCheck("cluster", admin.CachedCheck(sdk.CheckCluster, clusterCheckTTL, clusterCheckTimeout)),
+		admin.WithReadinessCheck("discovery", admin.DiscoveryCheck(serviceRepository)),
+		admin.WithReadinessCheck("certificates", admin.CertificatesCheck(viper.GetStringSlice(certificateFiles)...)),
+	}
+
+	if viper.IsSet(adminToken) {
+		options = append(options, admin.WithAPI(serviceRepository, viper.GetString(adminToken)))
+	}
+
+	return admin.NewServer(options...)
+}
+
+// serveAdmin serves the admin server at the address. It is meant to be run in
+// a goroutine and exits the process if the listener fails.
+func serveAdmin(address string, server *admin.Server) {
+	logger.WithField("address", address).Info("serving admin endpoints")
+
+	err := http.ListenAndServe(address, server)
+	if err != nil {
+		logger.
+			WithError(err).
+			Fatal("serving admin endpoints")
+	}
+}
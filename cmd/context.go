@@ -0,0 +1,78 @@
+// Copyright (c) 2017 off-sync
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/off-sync/platform-proxy-aws/services"
+	domain "github.com/off-sync/platform-proxy-domain/services"
+)
+
+// contextServiceRepository is a service repository whose methods also accept
+// a context.
+type contextServiceRepository interface {
+	domain.ServiceRepository
+	ListServicesWithContext(ctx context.Context) ([]string, error)
+	DescribeServiceWithContext(ctx context.Context, name string) (*domain.Service, error)
+	DescribeServiceDetailsWithContext(ctx context.Context, name string) (*services.ServiceDescription, error)
+}
+
+// boundServiceRepository binds a context to a service repository, so that
+// components calling the repository without a context, such as the proxy, the
+// routing table and the xDS server, pass it on to the AWS ECS API calls.
+type boundServiceRepository struct {
+	ctx        context.Context
+	repository contextServiceRepository
+}
+
+// withContext binds the context to the service repository.
+func withContext(ctx context.Context, repository contextServiceRepository) *boundServiceRepository {
+	return &boundServiceRepository{
+		ctx:        ctx,
+		repository: repository,
+	}
+}
+
+// ListServices lists the services using the bound context.
+func (r *boundServiceRepository) ListServices() ([]string, error) {
+	return r.repository.ListServicesWithContext(r.ctx)
+}
+
+// DescribeService describes the service using the bound context.
+func (r *boundServiceRepository) DescribeService(name string) (*domain.Service, error) {
+	return r.repository.DescribeServiceWithContext(r.ctx, name)
+}
+
+// DescribeServiceDetails describes the service using the bound context.
+func (r *boundServiceRepository) DescribeServiceDetails(name string) (*services.ServiceDescription, error) {
+	return r.repository.DescribeServiceDetailsWithContext(r.ctx, name)
+}
+
+// signalContext returns a context that is cancelled on an interrupt or
+// termination signal, so that commands stop calling the AWS ECS API and shut
+// down gracefully.
+func signalContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
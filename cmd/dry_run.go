@@ -0,0 +1,143 @@
+// Copyright (c) 2017 off-sync
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/off-sync/platform-proxy-aws/routing"
+)
+
+var (
+	dryRun       bool
+	dryRunDiff   string
+	dryRunSave   string
+	dryRunOutput string
+)
+
+// dryRunResult is the output of a dry run.
+type dryRunResult struct {
+	*routing.Table
+	Changes []*routing.Change `json:"changes,omitempty"`
+}
+
+// runDry performs a full discovery, prints the resulting routing table and
+// optionally compares it to and saves it as a snapshot. As no frontend source
+// is configured, the routing table consists of the discovered services.
+func runDry(serviceRepository routing.ServiceRepository) {
+	table, err := routing.BuildTable(serviceRepository)
+	if err != nil {
+		logger.
+			WithError(err).
+			Fatal("building routing table")
+
+		return
+	}
+
+	result := &dryRunResult{Table: table}
+
+	if dryRunDiff != "" {
+		old, err := readRoutingTable(dryRunDiff)
+		if err != nil {
+			logger.
+				WithError(err).
+				Fatal("reading routing table snapshot")
+
+			return
+		}
+
+		result.Changes = table.Diff(old)
+	}
+
+	err = writeOutput(os.Stdout, dryRunOutput, result, func(w io.Writer) {
+		fmt.Fprintln(w, "SERVICE\tBACKEND\tWEIGHT\tPROTOCOL\tECS SERVICE\tTASK DEFINITION")
+
+		for _, route := range table.Routes {
+			if route.Error != "" {
+				fmt.Fprintf(w, "%s\terror: %s\t\t\t\t\n", route.Service, route.Error)
+				continue
+			}
+
+			for _, backend := range route.Backends {
+				fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\n",
+					route.Service,
+					backend.URL,
+					backend.Weight,
+					backend.Protocol,
+					backend.Service,
+					backend.TaskDefinition)
+			}
+		}
+
+		if dryRunDiff == "" {
+			return
+		}
+
+		fmt.Fprintf(w, "\n%d changes compared to %s\n", len(result.Changes), dryRunDiff)
+
+		for _, change := range result.Changes {
+			fmt.Fprintln(w, change)
+		}
+	})
+	if err != nil {
+		logger.
+			WithError(err).
+			Fatal("writing routing table")
+
+		return
+	}
+
+	if dryRunSave != "" {
+		err = writeRoutingTable(dryRunSave, table)
+		if err != nil {
+			logger.
+				WithError(err).
+				Fatal("saving routing table snapshot")
+		}
+	}
+}
+
+func readRoutingTable(file string) (*routing.Table, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return routing.ReadTable(f)
+}
+
+func writeRoutingTable(file string, table *routing.Table) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+
+	err = table.Write(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2017 off-sync
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/off-sync/platform-proxy-aws/atomicfile"
+	"github.com/off-sync/platform-proxy-aws/export"
+	"github.com/off-sync/platform-proxy-aws/routing"
+)
+
+var (
+	exportFormat string
+	exportFile   string
+)
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Exports the discovered services as configuration for another proxy",
+	Long: `Exports the discovered services as configuration for another proxy, so that
+an existing proxy can route to the services discovered in ECS.
+
+Supported formats: ` + strings.Join(export.Formats(), ", "),
+	Args: cobra.NoArgs,
+	Run:  exportConfig,
+}
+
+func init() {
+	RootCmd.AddCommand(exportCmd)
+
+	exportCmd.Flags().StringVarP(&exportFormat, "format", "f", "nginx", fmt.Sprintf("configuration format: %s", strings.Join(export.Formats(), ", ")))
+	exportCmd.Flags().StringVar(&exportFile, "file", "", "write the configuration to this file instead of stdout")
+	exportCmd.Flags().StringVar(&export.CAFile, "ca-file", export.CAFile, "CA certificates file used by the proxy to verify https backends")
+}
+
+func exportConfig(cmd *cobra.Command, args []string) {
+	renderer, err := export.Lookup(exportFormat)
+	if err != nil {
+		logger.
+			WithError(err).
+			Fatal("looking up renderer")
+
+		return
+	}
+
+	serviceRepository, err := newServiceRepository()
+	if err != nil {
+		logger.
+			WithError(err).
+			Fatal("creating service repository")
+
+		return
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	table, err := routing.BuildTable(withContext(ctx, serviceRepository))
+	if err != nil {
+		logger.
+			WithError(err).
+			Fatal("building routing table")
+
+		return
+	}
+
+	// the configuration is rendered completely before it is written, so
+	// that a failure never leaves a partially written file
+	buf := &bytes.Buffer{}
+
+	err = renderer.Render(buf, table)
+	if err != nil {
+		logger.
+			WithError(err).
+			Fatal("rendering configuration")
+
+		return
+	}
+
+	if exportFile == "" {
+		_, err = buf.WriteTo(os.Stdout)
+	} else {
+		err = atomicfile.WriteFile(exportFile, buf.Bytes(), 0644)
+	}
+
+	if err != nil {
+		logger.
+			WithError(err).
+			Fatal("writing configuration")
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2017 off-sync
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/off-sync/platform-proxy-aws/filesd"
+)
+
+var (
+	fileSDFile     string
+	fileSDInterval time.Duration
+)
+
+// fileSDCmd represents the file-sd command
+var fileSDCmd = &cobra.Command{
+	Use:   "file-sd",
+	Short: "Continuously writes the discovered servers in Prometheus file_sd format",
+	Long: `Continuously writes the discovered servers in Prometheus file_sd format, so
+that Prometheus can scrape the same tasks the proxy routes to. Targets are
+labelled with the cluster, service, ECS service and task definition revision.
+The file is replaced atomically when the servers change.`,
+	Args: cobra.NoArgs,
+	Run:  runFileSD,
+}
+
+func init() {
+	RootCmd.AddCommand(fileSDCmd)
+
+	fileSDCmd.Flags().StringVar(&fileSDFile, "file", "", "file to write the targets to")
+	fileSDCmd.Flags().DurationVar(&fileSDInterval, "interval", filesd.DefaultInterval, "interval at which the services are discovered")
+}
+
+func runFileSD(cmd *cobra.Command, args []string) {
+	sdk, api, err := newAwsEcsAPI(nil)
+	if err != nil {
+		logger.
+			WithError(err).
+			Fatal("creating AWS ECS API")
+
+		return
+	}
+
+	serviceRepository, err := newServiceRepositoryForAPI(api)
+	if err != nil {
+		logger.
+			WithError(err).
+			Fatal("creating service repository")
+
+		return
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	writer, err := filesd.NewWriter(
+		withContext(ctx, serviceRepository),
+		fileSDFile,
+		filesd.WithInterval(fileSDInterval),
+		filesd.WithLabel(filesd.LabelCluster, sdk.ClusterName()),
+		filesd.WithErrorHandler(func(err error) {
+			logger.WithError(err).Error("writing file_sd targets")
+		}))
+	if err != nil {
+		logger.
+			WithError(err).
+			Fatal("creating file_sd writer")
+
+		return
+	}
+
+	logger.WithField("file", fileSDFile).Info("writing file_sd targets")
+
+	go func() {
+		<-ctx.Done()
+		writer.Stop()
+	}()
+
+	writer.Run()
+}
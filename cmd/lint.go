@@ -0,0 +1,147 @@
+// Copyright (c) 2017 off-sync
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/off-sync/platform-proxy-aws/services"
+)
+
+var lintOutput string
+
+// lintCmd represents the lint command
+var lintCmd = &cobra.Command{
+	Use:   "lint <task definition file>...",
+	Short: "Checks whether task definition files will be accepted by the proxy",
+	Long: `Checks whether task definition files will be accepted by the proxy by
+resolving their server containers and labels. Files may contain the output of
+'aws ecs describe-task-definition' or a bare task definition. No AWS access is
+required. Exits with a non-zero exit code if problems are found.`,
+	Args: cobra.MinimumNArgs(1),
+	Run:  lint,
+}
+
+func init() {
+	RootCmd.AddCommand(lintCmd)
+
+	lintCmd.Flags().StringVarP(&lintOutput, "output", "o", outputTable, "output format: table, json or yaml")
+}
+
+// fileReport is the lint report of a single task definition file.
+type fileReport struct {
+	File     string   `json:"file"`
+	Valid    bool     `json:"valid"`
+	Problems []string `json:"problems"`
+}
+
+func lint(cmd *cobra.Command, args []string) {
+	if err := checkOutputFormat(lintOutput); err != nil {
+		logger.
+			WithError(err).
+			Fatal("checking output format")
+
+		return
+	}
+
+	options, err := serviceRepositoryOptions()
+	if err != nil {
+		logger.
+			WithError(err).
+			Fatal("configuring service repository")
+
+		return
+	}
+
+	serviceRepository, err := services.NewServiceRepository(nil, options...)
+	if err != nil {
+		logger.
+			WithError(err).
+			Fatal("creating service repository")
+
+		return
+	}
+
+	valid := true
+
+	reports := make([]*fileReport, 0, len(args))
+
+	for _, file := range args {
+		report := &fileReport{
+			File:     file,
+			Problems: []string{},
+		}
+
+		for _, problem := range lintFile(serviceRepository, file) {
+			report.Problems = append(report.Problems, problem.Error())
+		}
+
+		report.Valid = len(report.Problems) < 1
+		valid = valid && report.Valid
+
+		reports = append(reports, report)
+	}
+
+	err = writeOutput(os.Stdout, lintOutput, reports, func(w io.Writer) {
+		fmt.Fprintln(w, "FILE\tSTATUS\tPROBLEM")
+
+		for _, report := range reports {
+			if report.Valid {
+				fmt.Fprintf(w, "%s\tOK\t\n", report.File)
+				continue
+			}
+
+			for _, problem := range report.Problems {
+				fmt.Fprintf(w, "%s\tINVALID\t%s\n", report.File, problem)
+			}
+		}
+	})
+	if err != nil {
+		logger.
+			WithError(err).
+			Fatal("writing lint report")
+
+		return
+	}
+
+	if !valid {
+		os.Exit(1)
+	}
+}
+
+func lintFile(serviceRepository *services.ServiceRepository, file string) []error {
+	f, err := os.Open(file)
+	if err != nil {
+		return []error{err}
+	}
+	defer f.Close()
+
+	tdef, err := services.ReadTaskDefinition(f)
+	if err != nil {
+		return []error{fmt.Errorf("reading task definition: %s", err)}
+	}
+
+	return serviceRepository.LintTaskDefinition(tdef)
+}
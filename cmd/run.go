@@ -21,14 +21,29 @@
 package cmd
 
 import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 
 	"github.com/off-sync/platform-proxy-app/infra/logging"
 	"github.com/off-sync/platform-proxy-app/proxies/cmd/startproxy"
-	"github.com/off-sync/platform-proxy-aws/infra"
+	"github.com/off-sync/platform-proxy-aws/healthcheck"
+	"github.com/off-sync/platform-proxy-aws/metrics"
+	"github.com/off-sync/platform-proxy-aws/outlier"
 	"github.com/off-sync/platform-proxy-aws/services"
 )
 
+// Configuration keys.
+const (
+	healthChecks                = "healthChecks"
+	healthCheckHealthyThreshold = "healthCheckHealthyThreshold"
+	outlierDetection            = "outlierDetection"
+	outlierConsecutiveErrors    = "outlierConsecutiveErrors"
+	outlierEjectionTime         = "outlierEjectionTime"
+)
+
 // runCmd represents the run command
 var runCmd = &cobra.Command{
 	Use:   "run",
@@ -49,10 +64,30 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// runCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	runCmd.Flags().BoolVar(&dryRun, "dry-run", false, "print the routing table and exit without starting the proxy")
+	runCmd.Flags().StringVar(&dryRunDiff, "diff", "", "dry run: print the changes compared to a routing table snapshot file")
+	runCmd.Flags().StringVar(&dryRunSave, "save", "", "dry run: save the routing table as a snapshot file")
+	runCmd.Flags().StringVarP(&dryRunOutput, "output", "o", outputTable, "dry run: output format: table, json or yaml")
 }
 
 func run(cmd *cobra.Command, args []string) {
-	api, err := infra.NewAwsEcsSdkFromConfig()
+	if dryRun {
+		if err := checkOutputFormat(dryRunOutput); err != nil {
+			logger.
+				WithError(err).
+				Fatal("checking output format")
+
+			return
+		}
+	}
+
+	var registry *prometheus.Registry
+
+	if viper.IsSet(metricsAddress) {
+		registry = newMetricsRegistry()
+	}
+
+	sdk, api, err := newAwsEcsAPI(registry)
 	if err != nil {
 		logger.
 			WithError(err).
@@ -61,7 +96,64 @@ func run(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	serviceRepository, err := services.NewServiceRepository(api)
+	shutdownTracing, err := setUpTracing(sdk.ClusterName())
+	if err != nil {
+		logger.
+			WithError(err).
+			Fatal("setting up tracing")
+
+		return
+	}
+	defer shutdownTracing()
+
+	// discovery is cancelled on an interrupt or termination signal
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	var options []services.ServiceRepositoryOption
+
+	var tracker *outlier.Tracker
+
+	if viper.GetBool(outlierDetection) {
+		tracker, err = newOutlierTracker()
+		if err != nil {
+			logger.
+				WithError(err).
+				Fatal("creating outlier tracker")
+
+			return
+		}
+	}
+
+	if viper.GetBool(healthChecks) {
+		checker, err := newHealthChecker()
+		if err != nil {
+			logger.
+				WithError(err).
+				Fatal("creating health checker")
+
+			return
+		}
+		defer checker.Stop()
+
+		// health checks stop on shutdown
+		go func() {
+			<-ctx.Done()
+			checker.Stop()
+		}()
+
+		options = append(options, services.WithServerFilters(checker))
+	}
+
+	if tracker != nil {
+		options = append(options, services.WithServerFilters(tracker))
+
+		// the proxy sends its requests to the servers using the default
+		// transport, so their outcome is reported to the tracker
+		http.DefaultTransport = outlier.NewRoundTripper(tracker, http.DefaultTransport)
+	}
+
+	serviceRepository, err := newServiceRepositoryForAPI(api, options...)
 	if err != nil {
 		logger.
 			WithError(err).
@@ -70,7 +162,57 @@ func run(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	svcs, err := serviceRepository.ListServices()
+	if dryRun {
+		runDry(withContext(ctx, serviceRepository))
+		return
+	}
+
+	if viper.IsSet(snapshotFile) {
+		file := viper.GetString(snapshotFile)
+
+		interval, err := persistInterval()
+		if err != nil {
+			logger.
+				WithError(err).
+				Fatal("configuring discovery snapshots")
+
+			return
+		}
+
+		restoreSnapshot(serviceRepository, file)
+
+		go persistSnapshots(ctx, serviceRepository, file, interval)
+	}
+
+	if viper.IsSet(adminAddress) {
+		adminServer, err := newAdminServer(sdk, serviceRepository)
+		if err != nil {
+			logger.
+				WithError(err).
+				Fatal("creating admin server")
+
+			return
+		}
+
+		go serveAdmin(viper.GetString(adminAddress), adminServer)
+	}
+
+	var proxyRepository contextServiceRepository = serviceRepository
+
+	if registry != nil {
+		proxyRepository, err = metrics.NewServiceRepository(serviceRepository, registry)
+		if err != nil {
+			logger.
+				WithError(err).
+				Fatal("instrumenting service repository")
+
+			return
+		}
+
+		go serveMetrics(viper.GetString(metricsAddress), registry)
+	}
+
+	svcs, err := proxyRepository.ListServicesWithContext(ctx)
 	if err != nil {
 		logger.WithError(err).Error("listing services")
 	} else {
@@ -80,7 +222,7 @@ func run(cmd *cobra.Command, args []string) {
 	}
 
 	startProxyCmd, err := startproxy.NewCommand(
-		serviceRepository,
+		withContext(ctx, proxyRepository),
 		nil,
 		logging.NewLogrusLogger(logger))
 	if err != nil {
@@ -89,5 +231,50 @@ func run(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	startProxyCmd.Execute(&startproxy.Model{})
+	proxyDone := make(chan error, 1)
+
+	go func() {
+		proxyDone <- startProxyCmd.Execute(&startproxy.Model{})
+	}()
+
+	// returning runs the deferred cleanup, such as stopping the health
+	// checks and flushing the traces
+	select {
+	case <-ctx.Done():
+		logger.Info("shutting down")
+	case err := <-proxyDone:
+		if err != nil {
+			logger.
+				WithError(err).
+				Error("running proxy")
+		}
+	}
+}
+
+// newHealthChecker creates a health checker using the configuration exposed
+// via viper.
+func newHealthChecker() (*healthcheck.Checker, error) {
+	var options []healthcheck.CheckerOption
+
+	if viper.IsSet(healthCheckHealthyThreshold) {
+		options = append(options, healthcheck.WithHealthyThreshold(viper.GetInt(healthCheckHealthyThreshold)))
+	}
+
+	return healthcheck.NewChecker(options...)
+}
+
+// newOutlierTracker creates an outlier tracker using the configuration exposed
+// via viper.
+func newOutlierTracker() (*outlier.Tracker, error) {
+	var options []outlier.TrackerOption
+
+	if viper.IsSet(outlierConsecutiveErrors) {
+		options = append(options, outlier.WithConsecutiveErrors(viper.GetInt(outlierConsecutiveErrors)))
+	}
+
+	if viper.IsSet(outlierEjectionTime) {
+		options = append(options, outlier.WithEjectionTime(viper.GetDuration(outlierEjectionTime)))
+	}
+
+	return outlier.NewTracker(options...)
 }
@@ -0,0 +1,281 @@
+// Copyright (c) 2017 off-sync
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/viper"
+
+	"github.com/off-sync/platform-proxy-aws/infra"
+	"github.com/off-sync/platform-proxy-aws/interfaces"
+	"github.com/off-sync/platform-proxy-aws/metrics"
+	"github.com/off-sync/platform-proxy-aws/ratelimit"
+	"github.com/off-sync/platform-proxy-aws/retry"
+	"github.com/off-sync/platform-proxy-aws/services"
+)
+
+// Configuration keys.
+const (
+	serverContainerNames = "serverContainerNames"
+	deploymentPolicy     = "deploymentPolicy"
+	canaryGrouping       = "canaryGrouping"
+	namedPorts           = "namedPorts"
+	retryMaxAttempts     = "retryMaxAttempts"
+	retryBaseDelay       = "retryBaseDelay"
+	retryMaxDelay        = "retryMaxDelay"
+	rateLimit            = "rateLimit"
+	rateLimitBurst       = "rateLimitBurst"
+	rateLimits           = "rateLimits"
+	maxStaleness         = "maxStaleness"
+	workers              = "workers"
+
+	healthCheckPath               = "healthCheckPath"
+	healthCheckInterval           = "healthCheckInterval"
+	healthCheckTimeout            = "healthCheckTimeout"
+	healthCheckUnhealthyThreshold = "healthCheckUnhealthyThreshold"
+)
+
+// newServiceRepository creates a service repository using the AWS ECS API and
+// the configuration exposed via viper. Additional options are applied after
+// the configured options.
+func newServiceRepository(options ...services.ServiceRepositoryOption) (*services.ServiceRepository, error) {
+	_, api, err := newAwsEcsAPI(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return newServiceRepositoryForAPI(api, options...)
+}
+
+// newAwsEcsAPI creates the AWS ECS API using the configuration exposed via
+// viper. It returns both the SDK and the API to use, which limits the rate of
+// calls and retries throttled and transient errors. If a registry is provided
+// the ECS API calls, including retries, are instrumented.
+//
+// The retries of the SDK itself are disabled, as they would multiply with
+// the retries of the API.
+func newAwsEcsAPI(registry *prometheus.Registry) (*infra.AwsEcsSdk, interfaces.AwsEcsAPI, error) {
+	sdk, err := infra.NewAwsEcsSdkFromConfig(aws.NewConfig().WithMaxRetries(0))
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating AWS ECS API: %s", err)
+	}
+
+	var api interfaces.AwsEcsAPI = sdk
+
+	if registry != nil {
+		api, err = metrics.NewAwsEcsAPI(api, registry)
+		if err != nil {
+			return nil, nil, fmt.Errorf("instrumenting AWS ECS API: %s", err)
+		}
+	}
+
+	api, err = ratelimit.NewAwsEcsAPI(api, rateLimitOptions()...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("configuring AWS ECS API rate limits: %s", err)
+	}
+
+	api, err = retry.NewAwsEcsAPI(api, retryOptions()...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("configuring AWS ECS API retries: %s", err)
+	}
+
+	return sdk, api, nil
+}
+
+// retryOptions returns the retry options based on the configuration exposed
+// via viper.
+func retryOptions() []retry.AwsEcsAPIOption {
+	var options []retry.AwsEcsAPIOption
+
+	if viper.IsSet(retryMaxAttempts) {
+		options = append(options, retry.WithMaxAttempts(viper.GetInt(retryMaxAttempts)))
+	}
+
+	if viper.IsSet(retryBaseDelay) || viper.IsSet(retryMaxDelay) {
+		baseDelay, maxDelay := retry.DefaultBaseDelay, retry.DefaultMaxDelay
+
+		if viper.IsSet(retryBaseDelay) {
+			baseDelay = viper.GetDuration(retryBaseDelay)
+		}
+
+		if viper.IsSet(retryMaxDelay) {
+			maxDelay = viper.GetDuration(retryMaxDelay)
+		}
+
+		options = append(options, retry.WithBackoff(baseDelay, maxDelay))
+	}
+
+	return options
+}
+
+// newServiceRepositoryForAPI creates a service repository using the provided
+// API and the configuration exposed via viper.
+func newServiceRepositoryForAPI(api interfaces.AwsEcsAPI, options ...services.ServiceRepositoryOption) (*services.ServiceRepository, error) {
+	configured, err := serviceRepositoryOptions()
+	if err != nil {
+		return nil, fmt.Errorf("configuring service repository: %s", err)
+	}
+
+	return services.NewServiceRepository(api, append(configured, options...)...)
+}
+
+// serviceRepositoryOptions returns the service repository options based on
+// the configuration exposed via viper.
+func serviceRepositoryOptions() ([]services.ServiceRepositoryOption, error) {
+	var options []services.ServiceRepositoryOption
+
+	if viper.IsSet(serverContainerNames) {
+		options = append(options, services.WithServerContainerNames(viper.GetStringSlice(serverContainerNames)...))
+	}
+
+	if viper.IsSet(deploymentPolicy) {
+		policy, err := services.ParseDeploymentPolicy(viper.GetString(deploymentPolicy))
+		if err != nil {
+			return nil, err
+		}
+
+		options = append(options, services.WithDeploymentPolicy(policy))
+	}
+
+	if viper.IsSet(canaryGrouping) {
+		options = append(options,
+			services.WithCanaryGrouping(viper.GetBool(canaryGrouping)),
+			services.WithCanaryErrorHandler(logCanaryError))
+	}
+
+	if viper.IsSet(namedPorts) {
+		options = append(options, services.WithNamedPorts(viper.GetBool(namedPorts)))
+	}
+
+	if viper.IsSet(workers) {
+		options = append(options, services.WithWorkers(viper.GetInt(workers)))
+	}
+
+	if healthCheck, configured := defaultHealthCheck(); configured {
+		options = append(options, services.WithDefaultHealthCheck(healthCheck))
+	}
+
+	if viper.IsSet(maxStaleness) {
+		options = append(options,
+			services.WithMaxStaleness(viper.GetDuration(maxStaleness)),
+			services.WithStaleHandler(logStale))
+	}
+
+	return options, nil
+}
+
+// defaultHealthCheck returns the health check for servers without health
+// check configuration based on the configuration exposed via viper, and
+// whether any of it is configured.
+func defaultHealthCheck() (services.HealthCheck, bool) {
+	healthCheck := services.DefaultHealthCheck
+	configured := false
+
+	if viper.IsSet(healthCheckPath) {
+		healthCheck.Path = viper.GetString(healthCheckPath)
+		configured = true
+	}
+
+	if viper.IsSet(healthCheckInterval) {
+		healthCheck.Interval = viper.GetDuration(healthCheckInterval)
+		configured = true
+	}
+
+	if viper.IsSet(healthCheckTimeout) {
+		healthCheck.Timeout = viper.GetDuration(healthCheckTimeout)
+		configured = true
+	}
+
+	if viper.IsSet(healthCheckUnhealthyThreshold) {
+		healthCheck.UnhealthyThreshold = viper.GetInt(healthCheckUnhealthyThreshold)
+		configured = true
+	}
+
+	return healthCheck, configured
+}
+
+// logStale logs a warning when stale discovery data is served.
+func logStale(name string, age time.Duration, err error) {
+	entry := logger.WithField("age", age)
+
+	if name != "" {
+		entry = entry.WithField("service", name)
+	}
+
+	entry.
+		WithError(err).
+		Warn("serving stale discovery data")
+}
+
+// logCanaryError logs a warning when a canary is left out of its service.
+func logCanaryError(name string, err error) {
+	logger.
+		WithField("service", name).
+		WithError(err).
+		Warn("leaving out canary")
+}
+
+// rateLimitOptions returns the rate limit options based on the configuration
+// exposed via viper. The rate limit applies to every method, unless it is
+// overridden for a method using e.g. rateLimits.DescribeService.rate and
+// rateLimits.DescribeService.burst.
+func rateLimitOptions() []ratelimit.AwsEcsAPIOption {
+	var options []ratelimit.AwsEcsAPIOption
+
+	r, burst := float64(ratelimit.DefaultRate), ratelimit.DefaultBurst
+
+	if viper.IsSet(rateLimit) {
+		r = viper.GetFloat64(rateLimit)
+	}
+
+	if viper.IsSet(rateLimitBurst) {
+		burst = viper.GetInt(rateLimitBurst)
+	}
+
+	options = append(options, ratelimit.WithLimit(r, burst))
+
+	for _, method := range ratelimit.Methods {
+		methodRate, methodBurst := r, burst
+
+		rateKey := rateLimits + "." + method + ".rate"
+		burstKey := rateLimits + "." + method + ".burst"
+
+		if !viper.IsSet(rateKey) && !viper.IsSet(burstKey) {
+			continue
+		}
+
+		if viper.IsSet(rateKey) {
+			methodRate = viper.GetFloat64(rateKey)
+		}
+
+		if viper.IsSet(burstKey) {
+			methodBurst = viper.GetInt(burstKey)
+		}
+
+		options = append(options, ratelimit.WithMethodLimit(method, methodRate, methodBurst))
+	}
+
+	return options
+}
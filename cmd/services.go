@@ -0,0 +1,235 @@
+// Copyright (c) 2017 off-sync
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/off-sync/platform-proxy-aws/services"
+)
+
+var servicesOutput string
+
+// servicesCmd represents the services command
+var servicesCmd = &cobra.Command{
+	Use:   "services",
+	Short: "Inspects the services discovered in the cluster",
+}
+
+// servicesListCmd represents the services list command
+var servicesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists all services with their task definitions and servers",
+	Args:  cobra.NoArgs,
+	Run:   servicesList,
+}
+
+// servicesDescribeCmd represents the services describe command
+var servicesDescribeCmd = &cobra.Command{
+	Use:   "describe <name>",
+	Short: "Describes a single service including its servers and labels",
+	Args:  cobra.ExactArgs(1),
+	Run:   servicesDescribe,
+}
+
+func init() {
+	RootCmd.AddCommand(servicesCmd)
+	servicesCmd.AddCommand(servicesListCmd, servicesDescribeCmd)
+
+	servicesCmd.PersistentFlags().StringVarP(&servicesOutput, "output", "o", outputTable, "output format: table, json or yaml")
+}
+
+// serviceListItem is a single service in the output of services list.
+type serviceListItem struct {
+	*services.ServiceDescription
+	Error string `json:"error,omitempty"`
+}
+
+func servicesList(cmd *cobra.Command, args []string) {
+	if err := checkOutputFormat(servicesOutput); err != nil {
+		logger.
+			WithError(err).
+			Fatal("checking output format")
+
+		return
+	}
+
+	serviceRepository, err := newServiceRepository()
+	if err != nil {
+		logger.
+			WithError(err).
+			Fatal("creating service repository")
+
+		return
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	names, err := serviceRepository.ListServicesWithContext(ctx)
+	if err != nil {
+		logger.
+			WithError(err).
+			Fatal("listing services")
+
+		return
+	}
+
+	items := make([]*serviceListItem, 0, len(names))
+
+	for _, name := range names {
+		item := &serviceListItem{}
+
+		item.ServiceDescription, err = serviceRepository.DescribeServiceDetailsWithContext(ctx, name)
+		if err != nil {
+			item.ServiceDescription = &services.ServiceDescription{Name: name}
+			item.Error = err.Error()
+		}
+
+		items = append(items, item)
+	}
+
+	err = writeOutput(os.Stdout, servicesOutput, items, func(w io.Writer) {
+		fmt.Fprintln(w, "NAME\tTASK DEFINITIONS\tSERVERS")
+
+		for _, item := range items {
+			if item.Error != "" {
+				fmt.Fprintf(w, "%s\t\terror: %s\n", item.Name, item.Error)
+				continue
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\n",
+				item.Name,
+				strings.Join(taskDefinitions(item.ServiceDescription), ","),
+				strings.Join(serverURLs(item.Servers()), ","))
+		}
+	})
+	if err != nil {
+		logger.
+			WithError(err).
+			Fatal("writing services")
+	}
+}
+
+func servicesDescribe(cmd *cobra.Command, args []string) {
+	if err := checkOutputFormat(servicesOutput); err != nil {
+		logger.
+			WithError(err).
+			Fatal("checking output format")
+
+		return
+	}
+
+	serviceRepository, err := newServiceRepository()
+	if err != nil {
+		logger.
+			WithError(err).
+			Fatal("creating service repository")
+
+		return
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	desc, err := serviceRepository.DescribeServiceDetailsWithContext(ctx, args[0])
+	if err != nil {
+		logger.
+			WithError(err).
+			WithField("name", args[0]).
+			Fatal("describing service")
+
+		return
+	}
+
+	err = writeOutput(os.Stdout, servicesOutput, desc, func(w io.Writer) {
+		fmt.Fprintf(w, "Name:\t%s\n", desc.Name)
+		fmt.Fprintf(w, "Task definitions:\t%s\n", strings.Join(taskDefinitions(desc), ","))
+
+		for _, set := range desc.ServerSets {
+			fmt.Fprintf(w, "\nService:\t%s\n", set.Service)
+			fmt.Fprintf(w, "Weight:\t%d\n", set.Weight)
+
+			fmt.Fprintln(w, "\nURL\tPROTOCOL\tCONTAINER\tTASK DEFINITION\tDEPLOYMENT\tSTATUS")
+
+			for _, server := range set.Servers {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+					server.URL,
+					server.Transport.Protocol,
+					server.Container,
+					server.TaskDefinition,
+					server.DeploymentID,
+					server.DeploymentStatus)
+			}
+
+			labels := make([]string, 0, len(set.Labels))
+			for label := range set.Labels {
+				labels = append(labels, label)
+			}
+
+			sort.Strings(labels)
+
+			fmt.Fprintln(w, "\nLABEL\tVALUE")
+
+			for _, label := range labels {
+				fmt.Fprintf(w, "%s\t%s\n", label, set.Labels[label])
+			}
+		}
+	})
+	if err != nil {
+		logger.
+			WithError(err).
+			Fatal("writing service")
+	}
+}
+
+// taskDefinitions returns the distinct task definitions of the servers of the
+// service.
+func taskDefinitions(desc *services.ServiceDescription) []string {
+	var tdefs []string
+
+	seen := make(map[string]bool)
+
+	for _, server := range desc.Servers() {
+		if !seen[server.TaskDefinition] {
+			seen[server.TaskDefinition] = true
+			tdefs = append(tdefs, server.TaskDefinition)
+		}
+	}
+
+	return tdefs
+}
+
+func serverURLs(servers []*services.Server) []string {
+	urls := make([]string, 0, len(servers))
+
+	for _, server := range servers {
+		urls = append(urls, server.URL.String())
+	}
+
+	return urls
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2017 off-sync
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/off-sync/platform-proxy-aws/services"
+)
+
+// Configuration keys.
+const (
+	snapshotFile     = "snapshotFile"
+	snapshotInterval = "snapshotInterval"
+)
+
+// defaultSnapshotInterval is the default interval at which the discovery
+// snapshot is persisted.
+const defaultSnapshotInterval = time.Minute
+
+// restoreSnapshot restores the service repository from the snapshot file, if
+// it exists, so that the proxy can serve before discovery has completed. As
+// restored data is stale, it is only served if a maximum staleness is
+// configured.
+func restoreSnapshot(repo *services.ServiceRepository, file string) {
+	if !viper.IsSet(maxStaleness) {
+		logger.
+			WithField("file", file).
+			Warn("not restoring discovery snapshot without maximum staleness")
+
+		return
+	}
+
+	snapshot, err := services.ReadSnapshotFile(file)
+	if os.IsNotExist(err) {
+		logger.WithField("file", file).Info("no discovery snapshot to restore")
+
+		return
+	}
+
+	if err != nil {
+		logger.
+			WithField("file", file).
+			WithError(err).
+			Warn("reading discovery snapshot")
+
+		return
+	}
+
+	repo.Restore(snapshot)
+
+	logger.
+		WithField("file", file).
+		WithField("discovered", snapshot.Discovered).
+		WithField("services", len(snapshot.Names)).
+		Info("restored discovery snapshot")
+}
+
+// persistInterval returns the configured interval at which the discovery
+// snapshot is persisted. It returns an error if the interval is not positive.
+func persistInterval() (time.Duration, error) {
+	interval := defaultSnapshotInterval
+
+	if viper.IsSet(snapshotInterval) {
+		interval = viper.GetDuration(snapshotInterval)
+	}
+
+	if interval <= 0 {
+		return 0, fmt.Errorf("invalid snapshot interval: %s", interval)
+	}
+
+	return interval, nil
+}
+
+// persistSnapshots reconciles a restored snapshot with live discovery data and
+// persists the discovery snapshot to the file at every interval, until the
+// context is done. It is meant to be run in a goroutine.
+func persistSnapshots(ctx context.Context, repo *services.ServiceRepository, file string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if repo.Restored() {
+			err := repo.RefreshWithContext(ctx)
+			if err != nil {
+				logger.
+					WithError(err).
+					Warn("reconciling restored discovery snapshot")
+			} else {
+				logger.Info("reconciled restored discovery snapshot")
+			}
+		}
+
+		// a restored snapshot is already persisted, and nothing is persisted
+		// before services have been discovered
+		snapshot := repo.Snapshot()
+
+		if !repo.Restored() && !snapshot.Discovered.IsZero() {
+			err := snapshot.WriteFile(file)
+			if err != nil {
+				logger.
+					WithField("file", file).
+					WithError(err).
+					Warn("writing discovery snapshot")
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
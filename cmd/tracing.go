@@ -0,0 +1,92 @@
+// Copyright (c) 2017 off-sync
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"github.com/off-sync/platform-proxy-aws/services"
+)
+
+// Configuration keys.
+const (
+	tracingExporter = "tracingExporter"
+)
+
+// Tracing exporters.
+const (
+	tracingExporterOTLP   = "otlp"
+	tracingExporterStdout = "stdout"
+)
+
+// setUpTracing configures the global tracer provider with the configured
+// exporter. The OTLP exporter is configured using the standard
+// OTEL_EXPORTER_OTLP_* environment variables. The returned function flushes
+// and stops the tracer provider.
+func setUpTracing(clusterName string) (func(), error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+
+	switch viper.GetString(tracingExporter) {
+	case "":
+		return func() {}, nil
+	case tracingExporterOTLP:
+		exporter, err = otlptracegrpc.New(context.Background())
+	case tracingExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stderr), stdouttrace.WithPrettyPrint())
+	default:
+		err = fmt.Errorf("invalid tracing exporter: %s", viper.GetString(tracingExporter))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", "platform-proxy-aws"),
+		services.AttributeCluster.String(clusterName)))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res))
+
+	otel.SetTracerProvider(provider)
+
+	return func() {
+		err := provider.Shutdown(context.Background())
+		if err != nil {
+			logger.WithError(err).Error("shutting down tracing")
+		}
+	}, nil
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2017 off-sync
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var validateOutput string
+
+// validateCmd represents the validate command
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Validates the docker labels of all services in the cluster",
+	Long: `Validates the docker labels of all services in the cluster by resolving
+their server containers, ports and labels. Exits with a non-zero exit code if
+problems are found.`,
+	Run: validate,
+}
+
+func init() {
+	RootCmd.AddCommand(validateCmd)
+
+	validateCmd.Flags().StringVarP(&validateOutput, "output", "o", outputTable, "output format: table, json or yaml")
+}
+
+// serviceReport is the validation report of a single service.
+type serviceReport struct {
+	Service  string   `json:"service"`
+	Valid    bool     `json:"valid"`
+	Problems []string `json:"problems"`
+}
+
+func validate(cmd *cobra.Command, args []string) {
+	if err := checkOutputFormat(validateOutput); err != nil {
+		logger.
+			WithError(err).
+			Fatal("checking output format")
+
+		return
+	}
+
+	serviceRepository, err := newServiceRepository()
+	if err != nil {
+		logger.
+			WithError(err).
+			Fatal("creating service repository")
+
+		return
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	validations, err := serviceRepository.ValidateServicesWithContext(ctx)
+	if err != nil {
+		logger.
+			WithError(err).
+			Fatal("validating services")
+
+		return
+	}
+
+	valid := true
+
+	reports := make([]*serviceReport, 0, len(validations))
+
+	for _, validation := range validations {
+		report := &serviceReport{
+			Service:  validation.Name,
+			Valid:    validation.Valid(),
+			Problems: []string{},
+		}
+
+		for _, problem := range validation.Problems {
+			report.Problems = append(report.Problems, problem.Error())
+		}
+
+		valid = valid && report.Valid
+
+		reports = append(reports, report)
+	}
+
+	err = writeOutput(os.Stdout, validateOutput, reports, func(w io.Writer) {
+		fmt.Fprintln(w, "SERVICE\tSTATUS\tPROBLEM")
+
+		for _, report := range reports {
+			if report.Valid {
+				fmt.Fprintf(w, "%s\tOK\t\n", report.Service)
+				continue
+			}
+
+			for _, problem := range report.Problems {
+				fmt.Fprintf(w, "%s\tINVALID\t%s\n", report.Service, problem)
+			}
+		}
+	})
+	if err != nil {
+		logger.
+			WithError(err).
+			Fatal("writing validation report")
+
+		return
+	}
+
+	if !valid {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2017 off-sync
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"net"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/off-sync/platform-proxy-aws/xds"
+)
+
+var (
+	xdsListen          string
+	xdsRefreshInterval time.Duration
+	xdsTrustedCA       string
+)
+
+// xdsCmd represents the xds command
+var xdsCmd = &cobra.Command{
+	Use:   "xds",
+	Short: "Runs an Envoy xDS management server serving the discovered services",
+	Long: `Runs an Envoy xDS management server serving the discovered services as
+clusters, endpoints and routes over gRPC. Changes are pushed to the connected
+proxies, which should use this server as their ADS server. Services are routed
+by the host names configured in their docker labels. The host names of the
+backends are resolved by this server, so it must use the same DNS as the
+proxies would.`,
+	Args: cobra.NoArgs,
+	Run:  runXDS,
+}
+
+func init() {
+	RootCmd.AddCommand(xdsCmd)
+
+	xdsCmd.Flags().StringVar(&xdsListen, "listen", ":18000", "address to serve xDS on")
+	xdsCmd.Flags().DurationVar(&xdsRefreshInterval, "refresh-interval", xds.DefaultRefreshInterval, "interval at which the services are discovered")
+	xdsCmd.Flags().StringVar(&xdsTrustedCA, "trusted-ca", xds.DefaultTrustedCA, "CA certificates file used by Envoy to verify https upstreams")
+}
+
+func runXDS(cmd *cobra.Command, args []string) {
+	serviceRepository, err := newServiceRepository()
+	if err != nil {
+		logger.
+			WithError(err).
+			Fatal("creating service repository")
+
+		return
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	server, err := xds.NewServer(
+		withContext(ctx, serviceRepository),
+		xds.WithRefreshInterval(xdsRefreshInterval),
+		xds.WithTrustedCA(xdsTrustedCA),
+		xds.WithErrorHandler(func(err error) {
+			logger.WithError(err).Error("refreshing xDS snapshot")
+		}))
+	if err != nil {
+		logger.
+			WithError(err).
+			Fatal("creating xDS server")
+
+		return
+	}
+
+	l, err := net.Listen("tcp", xdsListen)
+	if err != nil {
+		logger.
+			WithError(err).
+			Fatal("listening for xDS requests")
+
+		return
+	}
+
+	logger.WithField("address", l.Addr().String()).Info("serving xDS")
+
+	go func() {
+		<-ctx.Done()
+		server.Stop()
+	}()
+
+	err = server.Serve(l)
+	if err != nil {
+		logger.
+			WithError(err).
+			Fatal("serving xDS")
+	}
+}
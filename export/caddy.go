@@ -0,0 +1,123 @@
+// Copyright (c) 2017 off-sync
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package export
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/off-sync/platform-proxy-aws/routing"
+	"github.com/off-sync/platform-proxy-aws/services"
+)
+
+func init() {
+	Register("caddy", RendererFunc(renderCaddy))
+}
+
+// renderCaddy renders a Caddyfile snippet with a reverse_proxy directive for
+// every route, to be imported in a site block. Routes without backends that
+// receive traffic are left out, as are routes that failed to resolve. The
+// backends of a route must share their transport settings.
+func renderCaddy(w io.Writer, table *routing.Table) error {
+	for _, route := range table.Routes {
+		if route.Error != "" {
+			fmt.Fprintf(w, "# %s: %s\n\n", route.Service, route.Error)
+			continue
+		}
+
+		endpoints, err := endpoints(route)
+		if err != nil {
+			return err
+		}
+
+		if len(endpoints) < 1 {
+			fmt.Fprintf(w, "# %s: no backends receive traffic\n\n", route.Service)
+			continue
+		}
+
+		transport, err := routeTransport(route, endpoints)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(w, "# %s\n", route.Service)
+		fmt.Fprintf(w, "(%s) {\n", upstreamName(route.Service))
+		fmt.Fprint(w, "    reverse_proxy {\n")
+
+		var upstreams, weights []string
+
+		for _, e := range endpoints {
+			upstreams = append(upstreams, e.Address())
+			weights = append(weights, fmt.Sprint(e.Weight))
+		}
+
+		fmt.Fprintf(w, "        to %s\n", strings.Join(upstreams, " "))
+		fmt.Fprintf(w, "        lb_policy weighted_round_robin %s\n", strings.Join(weights, " "))
+
+		renderCaddyTransport(w, transport)
+
+		fmt.Fprint(w, "    }\n}\n\n")
+	}
+
+	return nil
+}
+
+// renderCaddyTransport renders the transport of the route, which Caddy only
+// supports per route.
+func renderCaddyTransport(w io.Writer, e *endpoint) {
+	var options []string
+
+	if e.Scheme == "https" {
+		options = append(options, "tls")
+
+		if e.TLSServerName != "" {
+			options = append(options, "tls_server_name "+e.TLSServerName)
+		}
+
+		if e.TLSSkipVerify {
+			options = append(options, "tls_insecure_skip_verify")
+		}
+	}
+
+	switch e.Protocol {
+	case services.ProtocolH2C:
+		options = append(options, "versions h2c 2")
+	case services.ProtocolGRPC:
+		if e.Scheme == "https" {
+			options = append(options, "versions 2")
+		} else {
+			options = append(options, "versions h2c 2")
+		}
+	}
+
+	if len(options) < 1 {
+		return
+	}
+
+	fmt.Fprint(w, "        transport http {\n")
+
+	for _, option := range options {
+		fmt.Fprintf(w, "            %s\n", option)
+	}
+
+	fmt.Fprint(w, "        }\n")
+}
@@ -0,0 +1,215 @@
+// Copyright (c) 2017 off-sync
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/off-sync/platform-proxy-aws/routing"
+	"github.com/off-sync/platform-proxy-aws/services"
+)
+
+func init() {
+	Register("envoy", RendererFunc(renderEnvoy))
+}
+
+// Envoy v3 cluster configuration, limited to the fields used.
+type envoyConfig struct {
+	Clusters []*envoyCluster `json:"clusters"`
+}
+
+type envoyCluster struct {
+	Name                 string                `json:"name"`
+	Type                 string                `json:"type"`
+	ConnectTimeout       string                `json:"connect_timeout"`
+	LbPolicy             string                `json:"lb_policy"`
+	Http2ProtocolOptions *struct{}             `json:"http2_protocol_options,omitempty"`
+	TransportSocket      *envoyTransportSocket `json:"transport_socket,omitempty"`
+	LoadAssignment       envoyLoadAssignment   `json:"load_assignment"`
+}
+
+type envoyTransportSocket struct {
+	Name        string                  `json:"name"`
+	TypedConfig envoyUpstreamTLSContext `json:"typed_config"`
+}
+
+type envoyUpstreamTLSContext struct {
+	Type             string                 `json:"@type"`
+	Sni              string                 `json:"sni,omitempty"`
+	CommonTLSContext *envoyCommonTLSContext `json:"common_tls_context,omitempty"`
+}
+
+type envoyCommonTLSContext struct {
+	ValidationContext envoyValidationContext `json:"validation_context"`
+}
+
+type envoyValidationContext struct {
+	TrustedCA                 envoyDataSource              `json:"trusted_ca"`
+	MatchTypedSubjectAltNames []envoySubjectAltNameMatcher `json:"match_typed_subject_alt_names"`
+}
+
+type envoyDataSource struct {
+	Filename string `json:"filename"`
+}
+
+type envoySubjectAltNameMatcher struct {
+	SanType string             `json:"san_type"`
+	Matcher envoyStringMatcher `json:"matcher"`
+}
+
+type envoyStringMatcher struct {
+	Exact string `json:"exact"`
+}
+
+type envoyLoadAssignment struct {
+	ClusterName string                   `json:"cluster_name"`
+	Endpoints   []envoyLocalityEndpoints `json:"endpoints"`
+}
+
+type envoyLocalityEndpoints struct {
+	LbEndpoints []envoyLbEndpoint `json:"lb_endpoints"`
+}
+
+type envoyLbEndpoint struct {
+	Endpoint            envoyEndpoint `json:"endpoint"`
+	LoadBalancingWeight int           `json:"load_balancing_weight"`
+}
+
+type envoyEndpoint struct {
+	Address envoyAddress `json:"address"`
+}
+
+type envoyAddress struct {
+	SocketAddress envoySocketAddress `json:"socket_address"`
+}
+
+type envoySocketAddress struct {
+	Address   string `json:"address"`
+	PortValue int    `json:"port_value"`
+}
+
+// EnvoyConnectTimeout is the connect timeout of the exported Envoy clusters.
+const EnvoyConnectTimeout = "5s"
+
+// renderEnvoy renders an Envoy cluster with its endpoints for every route, in
+// the JSON format of the static_resources section. Envoy only verifies
+// upstream certificates if a validation context is added, which is done
+// unless TLS skip verify is set.
+func renderEnvoy(w io.Writer, table *routing.Table) error {
+	config := &envoyConfig{Clusters: []*envoyCluster{}}
+
+	for _, route := range table.Routes {
+		if route.Error != "" {
+			continue
+		}
+
+		endpoints, err := endpoints(route)
+		if err != nil {
+			return err
+		}
+
+		cluster, err := envoyClusterOf(route, endpoints)
+		if err != nil {
+			return err
+		}
+
+		config.Clusters = append(config.Clusters, cluster)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(config)
+}
+
+// envoyClusterOf returns the Envoy cluster of the route with the provided
+// endpoints. The endpoints must share their protocol and TLS settings, which
+// apply to the cluster as a whole. For https endpoints this includes the name
+// their certificates are verified against.
+func envoyClusterOf(route *routing.Route, endpoints []*endpoint) (*envoyCluster, error) {
+	name := upstreamName(route.Service)
+
+	cluster := &envoyCluster{
+		Name:           name,
+		Type:           "STRICT_DNS",
+		ConnectTimeout: EnvoyConnectTimeout,
+		LbPolicy:       "ROUND_ROBIN",
+		LoadAssignment: envoyLoadAssignment{
+			ClusterName: name,
+			Endpoints:   []envoyLocalityEndpoints{{LbEndpoints: []envoyLbEndpoint{}}},
+		},
+	}
+
+	for _, e := range endpoints {
+		cluster.LoadAssignment.Endpoints[0].LbEndpoints = append(cluster.LoadAssignment.Endpoints[0].LbEndpoints, envoyLbEndpoint{
+			Endpoint: envoyEndpoint{Address: envoyAddress{SocketAddress: envoySocketAddress{
+				Address:   e.Host,
+				PortValue: e.Port,
+			}}},
+			LoadBalancingWeight: e.Weight,
+		})
+	}
+
+	if len(endpoints) < 1 {
+		return cluster, nil
+	}
+
+	first, err := routeTransport(route, endpoints)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range endpoints {
+		if e.Scheme == "https" && e.ServerName() != first.ServerName() {
+			return nil, fmt.Errorf("backends of service %s differ in TLS server name: %s and %s", route.Service, first.URL, e.URL)
+		}
+	}
+
+	if first.Protocol == services.ProtocolH2C || first.Protocol == services.ProtocolGRPC {
+		cluster.Http2ProtocolOptions = &struct{}{}
+	}
+
+	if first.Scheme == "https" {
+		cluster.TransportSocket = &envoyTransportSocket{
+			Name: "envoy.transport_sockets.tls",
+			TypedConfig: envoyUpstreamTLSContext{
+				Type: "type.googleapis.com/envoy.extensions.transport_sockets.tls.v3.UpstreamTlsContext",
+				Sni:  first.ServerName(),
+			},
+		}
+
+		if !first.TLSSkipVerify {
+			cluster.TransportSocket.TypedConfig.CommonTLSContext = &envoyCommonTLSContext{
+				ValidationContext: envoyValidationContext{
+					TrustedCA: envoyDataSource{Filename: CAFile},
+					MatchTypedSubjectAltNames: []envoySubjectAltNameMatcher{{
+						SanType: "DNS",
+						Matcher: envoyStringMatcher{Exact: first.ServerName()},
+					}},
+				},
+			}
+		}
+	}
+
+	return cluster, nil
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2017 off-sync
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package export
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/off-sync/platform-proxy-aws/routing"
+	"github.com/off-sync/platform-proxy-aws/services"
+)
+
+func init() {
+	Register("haproxy", RendererFunc(renderHAProxy))
+}
+
+// renderHAProxy renders a backend section for every route.
+func renderHAProxy(w io.Writer, table *routing.Table) error {
+	for _, route := range table.Routes {
+		if route.Error != "" {
+			fmt.Fprintf(w, "# %s: %s\n\n", route.Service, route.Error)
+			continue
+		}
+
+		endpoints, err := endpoints(route)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(w, "# %s\n", route.Service)
+		fmt.Fprintf(w, "backend %s\n", upstreamName(route.Service))
+		fmt.Fprint(w, "    balance roundrobin\n")
+
+		for i, e := range endpoints {
+			fmt.Fprintf(w, "    server %s-%d %s weight %d check", upstreamName(e.Service), i, e.Address(), e.Weight)
+
+			if e.Scheme == "https" {
+				fmt.Fprint(w, " ssl")
+
+				if e.TLSSkipVerify {
+					fmt.Fprint(w, " verify none")
+				} else {
+					fmt.Fprintf(w, " verify required ca-file %s verifyhost %s", CAFile, e.ServerName())
+				}
+
+				if e.TLSServerName != "" {
+					fmt.Fprintf(w, " sni str(%s)", e.TLSServerName)
+				}
+			}
+
+			if e.Protocol == services.ProtocolH2C || e.Protocol == services.ProtocolGRPC {
+				fmt.Fprint(w, " proto h2")
+			}
+
+			fmt.Fprint(w, "\n")
+		}
+
+		fmt.Fprint(w, "\n")
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2017 off-sync
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package export
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/off-sync/platform-proxy-aws/routing"
+)
+
+func init() {
+	Register("nginx", RendererFunc(renderNginx))
+}
+
+// renderNginx renders an upstream block for every route. The scheme of the
+// backends is added as a comment, as it must be used in proxy_pass.
+func renderNginx(w io.Writer, table *routing.Table) error {
+	for _, route := range table.Routes {
+		if route.Error != "" {
+			fmt.Fprintf(w, "# %s: %s\n\n", route.Service, route.Error)
+			continue
+		}
+
+		endpoints, err := endpoints(route)
+		if err != nil {
+			return err
+		}
+
+		if len(endpoints) < 1 {
+			// nginx does not accept upstream blocks without servers
+			fmt.Fprintf(w, "# %s: no backends receive traffic\n\n", route.Service)
+			continue
+		}
+
+		fmt.Fprintf(w, "# %s\n", route.Service)
+		fmt.Fprintf(w, "upstream %s {\n", upstreamName(route.Service))
+
+		for _, e := range endpoints {
+			fmt.Fprintf(w, "    server %s weight=%d; # %s %s\n", e.Address(), e.Weight, e.Scheme, e.Protocol)
+		}
+
+		fmt.Fprint(w, "}\n\n")
+	}
+
+	return nil
+}
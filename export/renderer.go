@@ -0,0 +1,232 @@
+// Copyright (c) 2017 off-sync
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package export renders the routing table in the configuration formats of
+// other proxies, so that they can use the services discovered in ECS.
+package export
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/off-sync/platform-proxy-aws/routing"
+)
+
+// CAFile is the file with the certificates of the CAs trusted to sign the
+// certificates of https backends, as referenced by the exported
+// configurations. It must exist on the hosts running the proxies.
+var CAFile = "/etc/ssl/certs/ca-certificates.crt"
+
+// Renderer renders a routing table in the configuration format of a proxy.
+type Renderer interface {
+	Render(w io.Writer, table *routing.Table) error
+}
+
+// RendererFunc adapts a function to the Renderer interface.
+type RendererFunc func(w io.Writer, table *routing.Table) error
+
+// Render implements the Renderer interface.
+func (f RendererFunc) Render(w io.Writer, table *routing.Table) error {
+	return f(w, table)
+}
+
+var renderers = make(map[string]Renderer)
+
+// Register makes a renderer available under the provided name. It panics if
+// a renderer is already registered under the name.
+func Register(name string, renderer Renderer) {
+	if _, found := renderers[name]; found {
+		panic(fmt.Sprintf("renderer already registered: %s", name))
+	}
+
+	renderers[name] = renderer
+}
+
+// Lookup returns the renderer registered under the provided name.
+func Lookup(name string) (Renderer, error) {
+	renderer, found := renderers[name]
+	if !found {
+		return nil, fmt.Errorf("unknown format: %s (available: %s)", name, strings.Join(Formats(), ", "))
+	}
+
+	return renderer, nil
+}
+
+// Formats returns the sorted names of all registered renderers.
+func Formats() []string {
+	names := make([]string, 0, len(renderers))
+
+	for name := range renderers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+var invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// upstreamName returns a name for the service that is valid in all supported
+// configuration formats. For service ARNs only the service name is used.
+func upstreamName(service string) string {
+	if i := strings.LastIndex(service, "/"); i >= 0 {
+		service = service[i+1:]
+	}
+
+	return invalidNameChars.ReplaceAllString(service, "_")
+}
+
+// endpoint is a backend of a route with its address split into host and port
+// and its weight relative to the other backends of the route.
+type endpoint struct {
+	*routing.Backend
+
+	Scheme string
+	Host   string
+	Port   int
+	Weight int
+}
+
+// ServerName returns the name the certificate of an https endpoint is
+// verified against: its TLS server name, or its host if it has none.
+func (e *endpoint) ServerName() string {
+	if e.TLSServerName != "" {
+		return e.TLSServerName
+	}
+
+	return e.Host
+}
+
+// Address returns the host and port of the endpoint.
+func (e *endpoint) Address() string {
+	return net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+}
+
+// MaxEndpointWeight is the largest weight of an exported endpoint, as HAProxy
+// does not accept weights above 256.
+const MaxEndpointWeight = 256
+
+// endpoints returns the backends of the route that receive traffic. The
+// weight of a backend is the weight of its ECS service divided over the
+// backends of that service, expressed in the smallest integers possible. If
+// that exceeds MaxEndpointWeight, the weights are approximated, keeping every
+// endpoint at a weight of at least one.
+func endpoints(route *routing.Route) ([]*endpoint, error) {
+	counts := make(map[string]int)
+	for _, backend := range route.Backends {
+		counts[backend.Service]++
+	}
+
+	l := 1
+	for _, n := range counts {
+		l = lcm(l, n)
+	}
+
+	var endpoints []*endpoint
+
+	g := 0
+
+	for _, backend := range route.Backends {
+		if backend.Weight <= 0 {
+			continue
+		}
+
+		u, err := url.Parse(backend.URL)
+		if err != nil {
+			return nil, err
+		}
+
+		port, err := strconv.Atoi(u.Port())
+		if err != nil {
+			return nil, fmt.Errorf("invalid backend URL: %s", backend.URL)
+		}
+
+		weight := backend.Weight * l / counts[backend.Service]
+		g = gcd(g, weight)
+
+		endpoints = append(endpoints, &endpoint{
+			Backend: backend,
+			Scheme:  u.Scheme,
+			Host:    u.Hostname(),
+			Port:    port,
+			Weight:  weight,
+		})
+	}
+
+	max := 0
+
+	for _, e := range endpoints {
+		e.Weight /= g
+
+		if e.Weight > max {
+			max = e.Weight
+		}
+	}
+
+	if max > MaxEndpointWeight {
+		for _, e := range endpoints {
+			e.Weight = int(math.Round(float64(e.Weight) * MaxEndpointWeight / float64(max)))
+			if e.Weight < 1 {
+				e.Weight = 1
+			}
+		}
+	}
+
+	return endpoints, nil
+}
+
+// routeTransport returns the endpoint whose transport settings apply to all
+// endpoints of the route, for formats that only support transport settings
+// per route. It returns an error if the endpoints differ in scheme, protocol
+// or TLS settings, as the route would be misconfigured otherwise.
+func routeTransport(route *routing.Route, endpoints []*endpoint) (*endpoint, error) {
+	first := endpoints[0]
+
+	for _, e := range endpoints[1:] {
+		if e.Scheme != first.Scheme ||
+			e.Protocol != first.Protocol ||
+			e.TLSServerName != first.TLSServerName ||
+			e.TLSSkipVerify != first.TLSSkipVerify {
+			return nil, fmt.Errorf("backends of service %s differ in transport settings: %s and %s", route.Service, first.URL, e.URL)
+		}
+	}
+
+	return first, nil
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+
+	return a
+}
+
+func lcm(a, b int) int {
+	return a / gcd(a, b) * b
+}
@@ -0,0 +1,192 @@
+package export
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/off-sync/platform-proxy-aws/routing"
+	"github.com/off-sync/platform-proxy-aws/services"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+var table = &routing.Table{Routes: []*routing.Route{
+	&routing.Route{
+		Service: "arn:aws:ecs:eu-west-1:123456789012:service/cluster/api#admin",
+		Backends: []*routing.Backend{
+			&routing.Backend{
+				URL:           "https://api-1:9000",
+				Weight:        100,
+				Protocol:      services.ProtocolGRPC,
+				TLSServerName: "api.internal",
+				TLSSkipVerify: true,
+				Service:       "api",
+			},
+		},
+	},
+	&routing.Route{
+		Service:  "broken",
+		Backends: []*routing.Backend{},
+		Error:    "invalid port: abc",
+	},
+	&routing.Route{
+		Service: "disabled",
+		Backends: []*routing.Backend{
+			&routing.Backend{
+				URL:      "http://disabled:8080",
+				Weight:   0,
+				Protocol: services.ProtocolHTTP1,
+				Service:  "disabled",
+			},
+		},
+	},
+	&routing.Route{
+		Service: "h2c",
+		Backends: []*routing.Backend{
+			&routing.Backend{
+				URL:      "http://h2c:8080",
+				Weight:   100,
+				Protocol: services.ProtocolH2C,
+				Service:  "h2c",
+			},
+		},
+	},
+	&routing.Route{
+		Service: "secure",
+		Backends: []*routing.Backend{
+			&routing.Backend{
+				URL:      "https://secure-1:8443",
+				Weight:   100,
+				Protocol: services.ProtocolHTTP1,
+				Service:  "secure",
+			},
+		},
+	},
+	&routing.Route{
+		Service: "web",
+		Backends: []*routing.Backend{
+			&routing.Backend{
+				URL:      "http://web-1:8080",
+				Weight:   90,
+				Protocol: services.ProtocolHTTP1,
+				Service:  "web",
+			},
+			&routing.Backend{
+				URL:      "http://web-2:8080",
+				Weight:   90,
+				Protocol: services.ProtocolHTTP1,
+				Service:  "web",
+			},
+			&routing.Backend{
+				URL:      "http://web-canary:8080",
+				Weight:   10,
+				Protocol: services.ProtocolHTTP1,
+				Service:  "web-canary",
+			},
+			&routing.Backend{
+				URL:      "http://web-disabled:8080",
+				Weight:   0,
+				Protocol: services.ProtocolHTTP1,
+				Service:  "web-disabled",
+			},
+		},
+	},
+}}
+
+func TestRenderers(t *testing.T) {
+	assert.EqualValues(t, []string{"caddy", "envoy", "haproxy", "nginx"}, Formats())
+
+	for _, format := range Formats() {
+		renderer, err := Lookup(format)
+		assert.Nil(t, err)
+
+		buf := &bytes.Buffer{}
+		assert.Nil(t, renderer.Render(buf, table), format)
+
+		golden := filepath.Join("testdata", format+".golden")
+
+		if *update {
+			assert.Nil(t, ioutil.WriteFile(golden, buf.Bytes(), 0644))
+		}
+
+		expected, err := ioutil.ReadFile(golden)
+		assert.Nil(t, err)
+		assert.Equal(t, string(expected), buf.String(), format)
+	}
+}
+
+func TestLookupUnknownFormat(t *testing.T) {
+	_, err := Lookup("apache")
+	assert.NotNil(t, err)
+}
+
+func TestRegisterTwicePanics(t *testing.T) {
+	assert.Panics(t, func() {
+		Register("nginx", RendererFunc(renderNginx))
+	})
+}
+
+func TestEndpointsWeights(t *testing.T) {
+	endpoints, err := endpoints(table.Route("web"))
+	assert.Nil(t, err)
+
+	var weights []int
+	for _, e := range endpoints {
+		weights = append(weights, e.Weight)
+	}
+
+	// 45% for each web server and 10% for the canary
+	assert.EqualValues(t, []int{9, 9, 2}, weights)
+}
+
+func TestEndpointsWeightsShouldNotExceedMaximum(t *testing.T) {
+	route := &routing.Route{Service: "web"}
+
+	// 97% over 7 servers and 3% over 11 servers needs 1067 : 21 exactly
+	for i := 0; i < 7; i++ {
+		route.Backends = append(route.Backends, &routing.Backend{
+			URL:     fmt.Sprintf("http://web-%d:8080", i),
+			Weight:  97,
+			Service: "web",
+		})
+	}
+
+	for i := 0; i < 11; i++ {
+		route.Backends = append(route.Backends, &routing.Backend{
+			URL:     fmt.Sprintf("http://canary-%d:8080", i),
+			Weight:  3,
+			Service: "canary",
+		})
+	}
+
+	endpoints, err := endpoints(route)
+	assert.Nil(t, err)
+
+	assert.Equal(t, MaxEndpointWeight, endpoints[0].Weight)
+	assert.Equal(t, 5, endpoints[7].Weight)
+}
+
+func TestRenderersShouldRejectMixedTransportSettings(t *testing.T) {
+	mixed := &routing.Table{Routes: []*routing.Route{
+		&routing.Route{
+			Service: "web",
+			Backends: []*routing.Backend{
+				&routing.Backend{URL: "http://web:8080", Weight: 90, Service: "web"},
+				&routing.Backend{URL: "https://canary:8443", Weight: 10, Service: "canary"},
+			},
+		},
+	}}
+
+	for _, format := range []string{"caddy", "envoy"} {
+		renderer, err := Lookup(format)
+		assert.Nil(t, err)
+
+		assert.NotNil(t, renderer.Render(&bytes.Buffer{}, mixed), format)
+	}
+}
@@ -0,0 +1,280 @@
+// Copyright (c) 2017 off-sync
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package filesd writes the servers discovered in ECS in the Prometheus
+// file_sd format, so that Prometheus and other tools supporting it can scrape
+// the same tasks the proxy routes to.
+package filesd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/off-sync/platform-proxy-aws/atomicfile"
+	"github.com/off-sync/platform-proxy-aws/routing"
+	"github.com/off-sync/platform-proxy-aws/services"
+)
+
+// Default values for the Writer struct.
+const (
+	DefaultInterval = 30 * time.Second
+)
+
+// Labels added to the target groups.
+const (
+	LabelCluster                = "cluster"
+	LabelService                = "service"
+	LabelECSService             = "ecs_service"
+	LabelTaskDefinition         = "task_definition"
+	LabelTaskDefinitionRevision = "task_definition_revision"
+	LabelDeploymentStatus       = "deployment_status"
+)
+
+// TargetGroup is a group of targets sharing the same labels.
+type TargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// Writer periodically writes the servers of all services of a repository to
+// a file. The file is only replaced if its contents have changed, and is
+// replaced atomically so that readers never see a partially written file.
+type Writer struct {
+	repository   routing.ServiceRepository
+	file         string
+	interval     time.Duration
+	labels       map[string]string
+	errorHandler func(error)
+
+	mutex    sync.Mutex
+	written  []byte
+	services map[string][]*TargetGroup
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// WriterOption defines the type used to further configure a Writer.
+type WriterOption func(*Writer) error
+
+// NewWriter creates a new writer for the provided service repository and
+// file.
+func NewWriter(repository routing.ServiceRepository, file string, options ...WriterOption) (*Writer, error) {
+	if file == "" {
+		return nil, fmt.Errorf("no file provided")
+	}
+
+	w := &Writer{
+		repository:   repository,
+		file:         file,
+		interval:     DefaultInterval,
+		labels:       make(map[string]string),
+		errorHandler: func(error) {},
+		services:     make(map[string][]*TargetGroup),
+		done:         make(chan struct{}),
+	}
+
+	for _, opt := range options {
+		err := opt(w)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return w, nil
+}
+
+// WithInterval configures a writer with the provided interval at which the
+// services are discovered.
+func WithInterval(interval time.Duration) WriterOption {
+	return func(w *Writer) error {
+		if interval <= 0 {
+			return fmt.Errorf("invalid interval: %s", interval)
+		}
+
+		w.interval = interval
+		return nil
+	}
+}
+
+// WithLabel configures a writer to add the provided label to all target
+// groups, e.g. the cluster name.
+func WithLabel(name, value string) WriterOption {
+	return func(w *Writer) error {
+		w.labels[name] = value
+		return nil
+	}
+}
+
+// WithErrorHandler configures a writer with a handler that is called when a
+// periodic write fails or a service cannot be described.
+func WithErrorHandler(handler func(error)) WriterOption {
+	return func(w *Writer) error {
+		w.errorHandler = handler
+		return nil
+	}
+}
+
+// TargetGroups returns the target groups of all services of the repository.
+// The servers of a service are grouped by ECS service and task definition.
+// If a service cannot be described, its last target groups are used, so that
+// a temporary failure does not remove its targets. Services that have never
+// been described are left out.
+func (w *Writer) TargetGroups() ([]*TargetGroup, error) {
+	names, err := w.repository.ListServices()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(names)
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	groups := []*TargetGroup{}
+	described := make(map[string][]*TargetGroup, len(names))
+
+	for _, name := range names {
+		desc, err := w.repository.DescribeServiceDetails(name)
+		if err != nil {
+			w.errorHandler(fmt.Errorf("describing %s: %s", name, err))
+
+			if last, found := w.services[name]; found {
+				described[name] = last
+				groups = append(groups, last...)
+			}
+
+			continue
+		}
+
+		serviceGroups := w.serviceTargetGroups(name, desc)
+
+		described[name] = serviceGroups
+		groups = append(groups, serviceGroups...)
+	}
+
+	w.services = described
+
+	return groups, nil
+}
+
+// serviceTargetGroups returns the target groups of a single service.
+func (w *Writer) serviceTargetGroups(name string, desc *services.ServiceDescription) []*TargetGroup {
+	groups := []*TargetGroup{}
+
+	for _, set := range desc.ServerSets {
+		byTaskDefinition := make(map[string]*TargetGroup)
+
+		for _, server := range set.Servers {
+			group, found := byTaskDefinition[server.TaskDefinition]
+			if !found {
+				group = &TargetGroup{
+					Targets: []string{},
+					Labels:  w.groupLabels(name, set.Service),
+				}
+
+				group.Labels[LabelTaskDefinition] = server.TaskDefinition
+				group.Labels[LabelTaskDefinitionRevision] = strconv.FormatInt(server.Revision, 10)
+				group.Labels[LabelDeploymentStatus] = server.DeploymentStatus
+
+				byTaskDefinition[server.TaskDefinition] = group
+				groups = append(groups, group)
+			}
+
+			group.Targets = append(group.Targets, server.URL.Host)
+		}
+	}
+
+	return groups
+}
+
+func (w *Writer) groupLabels(service, ecsService string) map[string]string {
+	labels := make(map[string]string, len(w.labels)+5)
+
+	for name, value := range w.labels {
+		labels[name] = value
+	}
+
+	labels[LabelService] = service
+	labels[LabelECSService] = ecsService
+
+	return labels
+}
+
+// Write writes the target groups to the file if they have changed since the
+// last write. It returns whether the file was written.
+func (w *Writer) Write() (bool, error) {
+	groups, err := w.TargetGroups()
+	if err != nil {
+		return false, err
+	}
+
+	data, err := json.MarshalIndent(groups, "", "  ")
+	if err != nil {
+		return false, err
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.written != nil && bytes.Equal(data, w.written) {
+		return false, nil
+	}
+
+	err = atomicfile.WriteFile(w.file, data, 0644)
+	if err != nil {
+		return false, err
+	}
+
+	w.written = data
+
+	return true, nil
+}
+
+// Run writes the target groups immediately and then at every interval, until
+// Stop is called.
+func (w *Writer) Run() {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		_, err := w.Write()
+		if err != nil {
+			w.errorHandler(err)
+		}
+
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Stop stops the writer.
+func (w *Writer) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.done)
+	})
+}
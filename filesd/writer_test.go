@@ -0,0 +1,193 @@
+package filesd
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/off-sync/platform-proxy-aws/interfaces"
+	"github.com/off-sync/platform-proxy-aws/routing"
+	"github.com/off-sync/platform-proxy-aws/services"
+)
+
+func addService(api *interfaces.AwsEcsAPIMock, name, hostname string, labels map[string]string) {
+	api.ServiceNames = append(api.ServiceNames, name)
+
+	api.Services[name] = &ecs.Service{
+		ServiceName:    aws.String(name),
+		TaskDefinition: aws.String(name + "TaskDef"),
+	}
+
+	api.TaskDefs[name+"TaskDef"] = &ecs.TaskDefinition{
+		ContainerDefinitions: []*ecs.ContainerDefinition{
+			&ecs.ContainerDefinition{
+				DockerLabels: aws.StringMap(labels),
+				Name:         aws.String(services.DefaultServerContainerName),
+				Hostname:     aws.String(hostname),
+			},
+		},
+		Revision: aws.Int64(3),
+	}
+}
+
+func setUp(t *testing.T) (*Writer, *interfaces.AwsEcsAPIMock, string) {
+	api := interfaces.NewAwsEcsAPIMock()
+
+	r, err := services.NewServiceRepository(api, services.WithCanaryGrouping(true))
+	assert.Nil(t, err)
+
+	addService(api, "web", "web", nil)
+	addService(api, "web-canary", "canary", map[string]string{
+		services.DefaultDockerLabelCanaryOf: "web",
+		services.DefaultDockerLabelWeight:   "10",
+	})
+	addService(api, "api", "api", map[string]string{
+		services.DefaultDockerLabelPort: "abc",
+	})
+
+	dir, err := ioutil.TempDir("", "filesd")
+	assert.Nil(t, err)
+
+	w, err := NewWriter(r, filepath.Join(dir, "targets.json"), WithLabel(LabelCluster, "test"))
+	assert.Nil(t, err)
+
+	return w, api, dir
+}
+
+func TestTargetGroups(t *testing.T) {
+	w, _, dir := setUp(t)
+	defer os.RemoveAll(dir)
+
+	groups, err := w.TargetGroups()
+	assert.Nil(t, err)
+
+	// the api service has an error and is left out
+	assert.EqualValues(t, []*TargetGroup{
+		&TargetGroup{
+			Targets: []string{"web:8080"},
+			Labels: map[string]string{
+				LabelCluster:                "test",
+				LabelService:                "web",
+				LabelECSService:             "web",
+				LabelTaskDefinition:         "webTaskDef",
+				LabelTaskDefinitionRevision: "3",
+				LabelDeploymentStatus:       services.DeploymentStatusPrimary,
+			},
+		},
+		&TargetGroup{
+			Targets: []string{"canary:8080"},
+			Labels: map[string]string{
+				LabelCluster:                "test",
+				LabelService:                "web",
+				LabelECSService:             "web-canary",
+				LabelTaskDefinition:         "web-canaryTaskDef",
+				LabelTaskDefinitionRevision: "3",
+				LabelDeploymentStatus:       services.DeploymentStatusPrimary,
+			},
+		},
+	}, groups)
+}
+
+func TestWriteShouldOnlyWriteChanges(t *testing.T) {
+	w, api, dir := setUp(t)
+	defer os.RemoveAll(dir)
+
+	written, err := w.Write()
+	assert.Nil(t, err)
+	assert.True(t, written)
+
+	written, err = w.Write()
+	assert.Nil(t, err)
+	assert.False(t, written)
+
+	addService(api, "admin", "admin", nil)
+
+	written, err = w.Write()
+	assert.Nil(t, err)
+	assert.True(t, written)
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "targets.json"))
+	assert.Nil(t, err)
+
+	var groups []*TargetGroup
+	assert.Nil(t, json.Unmarshal(data, &groups))
+	assert.Len(t, groups, 3)
+
+	// no temporary files are left behind
+	files, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestWriteShouldKeepFileWhenAPIFails(t *testing.T) {
+	w, api, dir := setUp(t)
+	defer os.RemoveAll(dir)
+
+	_, err := w.Write()
+	assert.Nil(t, err)
+
+	api.FailListServices = true
+
+	written, err := w.Write()
+	assert.NotNil(t, err)
+	assert.False(t, written)
+
+	_, err = os.Stat(filepath.Join(dir, "targets.json"))
+	assert.Nil(t, err)
+}
+
+func TestNewWriterShouldRequireFile(t *testing.T) {
+	_, err := NewWriter(nil, "")
+	assert.NotNil(t, err)
+}
+
+// failingRepository fails to describe the services while fail is set.
+type failingRepository struct {
+	routing.ServiceRepository
+	fail bool
+}
+
+func (r *failingRepository) DescribeServiceDetails(name string) (*services.ServiceDescription, error) {
+	if r.fail {
+		return nil, errors.New("throttled")
+	}
+
+	return r.ServiceRepository.DescribeServiceDetails(name)
+}
+
+func TestTargetGroupsShouldKeepServicesThatTemporarilyFail(t *testing.T) {
+	w, api, dir := setUp(t)
+	defer os.RemoveAll(dir)
+
+	repository := &failingRepository{ServiceRepository: w.repository}
+	w.repository = repository
+
+	var errs []error
+	w.errorHandler = func(err error) { errs = append(errs, err) }
+
+	groups, err := w.TargetGroups()
+	assert.Nil(t, err)
+	assert.Len(t, groups, 2)
+
+	// the services can temporarily not be described
+	repository.fail = true
+
+	failing, err := w.TargetGroups()
+	assert.Nil(t, err)
+	assert.EqualValues(t, groups, failing)
+	assert.NotEmpty(t, errs)
+
+	// a service that is no longer listed is removed
+	api.ServiceNames = []string{"api"}
+
+	groups, err = w.TargetGroups()
+	assert.Nil(t, err)
+	assert.Len(t, groups, 0)
+}
@@ -0,0 +1,337 @@
+// Copyright (c) 2017 off-sync
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package healthcheck actively checks the health of the servers discovered by
+// the service repository.
+package healthcheck
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/off-sync/platform-proxy-aws/services"
+)
+
+// Default values for the Checker struct.
+const (
+	DefaultHealthyThreshold = 2
+	DefaultTargetExpiry     = 5 * time.Minute
+)
+
+// Checker periodically probes servers and keeps track of their health. HTTP
+// servers are probed using GET requests, over HTTP/2 without TLS for h2c
+// servers, and gRPC servers using the gRPC health checking protocol. Servers are checked from the moment they are first passed
+// to FilterServers until they have not been passed for the target expiry.
+//
+// Checker implements the services.ServerFilter interface.
+type Checker struct {
+	healthyThreshold int
+	targetExpiry     time.Duration
+
+	mutex   sync.Mutex
+	targets map[string]*target
+	done    chan struct{}
+	stopped bool
+}
+
+// target holds the health state of a single server.
+type target struct {
+	server *services.Server
+	client *http.Client
+
+	healthy   bool
+	failures  int
+	successes int
+	lastSeen  time.Time
+}
+
+// CheckerOption defines the type used to further configure a Checker.
+type CheckerOption func(*Checker) error
+
+// NewChecker creates a new health checker.
+func NewChecker(options ...CheckerOption) (*Checker, error) {
+	c := &Checker{
+		healthyThreshold: DefaultHealthyThreshold,
+		targetExpiry:     DefaultTargetExpiry,
+		targets:          make(map[string]*target),
+		done:             make(chan struct{}),
+	}
+
+	for _, opt := range options {
+		err := opt(c)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// WithHealthyThreshold configures a checker with the provided number of
+// consecutive passed checks after which an unhealthy server is considered
+// healthy again.
+func WithHealthyThreshold(threshold int) CheckerOption {
+	return func(c *Checker) error {
+		if threshold < 1 {
+			return fmt.Errorf("invalid healthy threshold: %d", threshold)
+		}
+
+		c.healthyThreshold = threshold
+		return nil
+	}
+}
+
+// WithTargetExpiry configures a checker with the provided duration after which
+// servers that have not been passed to FilterServers are no longer checked.
+func WithTargetExpiry(expiry time.Duration) CheckerOption {
+	return func(c *Checker) error {
+		c.targetExpiry = expiry
+		return nil
+	}
+}
+
+// FilterServers returns the servers that are healthy. Servers that are not
+// yet known are considered healthy and are checked from now on. Servers that
+// are known are checked using their latest timeout and TLS settings.
+func (c *Checker) FilterServers(servers []*services.Server) []*services.Server {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var healthy []*services.Server
+
+	for _, server := range servers {
+		key := server.URL.String()
+
+		t, found := c.targets[key]
+		if !found {
+			t = c.newTarget(server)
+
+			if !c.stopped {
+				c.targets[key] = t
+				go c.run(key, t)
+			}
+		} else if server.Transport != t.server.Transport ||
+			server.HealthCheck.Timeout != t.server.HealthCheck.Timeout {
+			t.client = c.newClient(server)
+		}
+
+		t.server = server
+		t.lastSeen = time.Now()
+
+		if t.healthy {
+			healthy = append(healthy, server)
+		}
+	}
+
+	return healthy
+}
+
+// IsHealthy returns whether the server with the provided URL is healthy.
+// Servers that are not checked are considered healthy.
+func (c *Checker) IsHealthy(serverURL string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	t, found := c.targets[serverURL]
+
+	return !found || t.healthy
+}
+
+// Stop stops checking all servers.
+func (c *Checker) Stop() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.stopped {
+		c.stopped = true
+		close(c.done)
+	}
+}
+
+func (c *Checker) newTarget(server *services.Server) *target {
+	return &target{
+		server:  server,
+		client:  c.newClient(server),
+		healthy: true,
+	}
+}
+
+// newClient creates the HTTP client used to check the server, using its
+// health check timeout, protocol and TLS settings. Checks never go through an
+// HTTP proxy.
+func (c *Checker) newClient(server *services.Server) *http.Client {
+	transport := &http.Transport{
+		TLSClientConfig:   tlsConfig(server),
+		DisableKeepAlives: true,
+	}
+
+	if server.Transport.Protocol == services.ProtocolH2C {
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   server.HealthCheck.Timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// run checks the target every health check interval until the checker is
+// stopped or the target expires.
+func (c *Checker) run(key string, t *target) {
+	for {
+		c.mutex.Lock()
+		interval := t.server.HealthCheck.Interval
+		expired := time.Since(t.lastSeen) > c.targetExpiry
+		if expired {
+			delete(c.targets, key)
+		}
+		c.mutex.Unlock()
+
+		if expired {
+			return
+		}
+
+		select {
+		case <-c.done:
+			return
+		case <-time.After(interval):
+		}
+
+		c.mutex.Lock()
+		server, client := t.server, t.client
+		c.mutex.Unlock()
+
+		var err error
+
+		if server.Transport.Protocol == services.ProtocolGRPC {
+			err = CheckGRPC(server)
+		} else {
+			err = Check(client, server)
+		}
+
+		c.mutex.Lock()
+		c.record(t, err == nil)
+		c.mutex.Unlock()
+	}
+}
+
+// record updates the health state of the target with the result of a check.
+func (c *Checker) record(t *target, passed bool) {
+	if passed {
+		t.failures = 0
+		t.successes++
+
+		if !t.healthy && t.successes >= c.healthyThreshold {
+			t.healthy = true
+		}
+
+		return
+	}
+
+	t.successes = 0
+	t.failures++
+
+	if t.healthy && t.failures >= t.server.HealthCheck.UnhealthyThreshold {
+		t.healthy = false
+	}
+}
+
+// Check performs a single health check of the server using the provided HTTP
+// client. It returns an error if the request fails or the response status
+// code is not the expected status code.
+func Check(client *http.Client, server *services.Server) error {
+	checkURL := *server.URL
+	checkURL.Path = ""
+	checkURL.RawQuery = ""
+
+	resp, err := client.Get(checkURL.String() + server.HealthCheck.Path)
+	if err != nil {
+		return err
+	}
+
+	resp.Body.Close()
+
+	expected := server.HealthCheck.ExpectedStatus
+
+	if expected == 0 && (resp.StatusCode < 200 || resp.StatusCode > 399) ||
+		expected != 0 && resp.StatusCode != expected {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// CheckGRPC performs a single health check of the gRPC server using the gRPC
+// health checking protocol, within the health check timeout. It returns an
+// error if the call fails or the server is not serving.
+func CheckGRPC(server *services.Server) error {
+	creds := insecure.NewCredentials()
+	if server.URL.Scheme == "https" {
+		creds = credentials.NewTLS(tlsConfig(server))
+	}
+
+	conn, err := grpc.NewClient(server.URL.Host, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	ctx := context.Background()
+
+	if server.HealthCheck.Timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, server.HealthCheck.Timeout)
+		defer cancel()
+	}
+
+	resp, err := grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+	if err != nil {
+		return err
+	}
+
+	if resp.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	return nil
+}
+
+// tlsConfig returns the TLS configuration used to check the server.
+func tlsConfig(server *services.Server) *tls.Config {
+	return &tls.Config{
+		ServerName:         server.Transport.TLSServerName,
+		InsecureSkipVerify: server.Transport.TLSSkipVerify,
+	}
+}
@@ -0,0 +1,194 @@
+package healthcheck
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/off-sync/platform-proxy-aws/services"
+)
+
+func newServer(t *testing.T, rawURL string, healthCheck services.HealthCheck) *services.Server {
+	u, err := url.Parse(rawURL)
+	assert.Nil(t, err)
+
+	return &services.Server{
+		URL:         u,
+		HealthCheck: healthCheck,
+	}
+}
+
+func fastHealthCheck(path string) services.HealthCheck {
+	return services.HealthCheck{
+		Path:               path,
+		Interval:           5 * time.Millisecond,
+		Timeout:            time.Second,
+		UnhealthyThreshold: 2,
+	}
+}
+
+// waitFor polls the condition until it holds or a second has passed.
+func waitFor(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(time.Second)
+
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met within a second")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/health":
+			w.WriteHeader(http.StatusOK)
+		case "/created":
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	client := ts.Client()
+
+	assert.Nil(t, Check(client, newServer(t, ts.URL, fastHealthCheck("/health"))))
+	assert.NotNil(t, Check(client, newServer(t, ts.URL, fastHealthCheck("/down"))))
+
+	healthCheck := fastHealthCheck("/created")
+	assert.Nil(t, Check(client, newServer(t, ts.URL, healthCheck)))
+
+	healthCheck.ExpectedStatus = http.StatusOK
+	assert.NotNil(t, Check(client, newServer(t, ts.URL, healthCheck)))
+
+	assert.NotNil(t, Check(client, newServer(t, "http://127.0.0.1:1", healthCheck)))
+}
+
+func TestCheckH2C(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			w.WriteHeader(http.StatusHTTPVersionNotSupported)
+		}
+	}))
+	ts.Config.Protocols = new(http.Protocols)
+	ts.Config.Protocols.SetUnencryptedHTTP2(true)
+	ts.Start()
+	defer ts.Close()
+
+	c, err := NewChecker()
+	assert.Nil(t, err)
+
+	server := newServer(t, ts.URL, fastHealthCheck("/"))
+	server.Transport.Protocol = services.ProtocolH2C
+
+	assert.Nil(t, Check(c.newClient(server), server))
+}
+
+func TestCheckGRPC(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	healthServer := health.NewServer()
+
+	s := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(s, healthServer)
+
+	go s.Serve(lis)
+	defer s.Stop()
+
+	server := newServer(t, "http://"+lis.Addr().String(), fastHealthCheck("/"))
+	server.Transport.Protocol = services.ProtocolGRPC
+
+	assert.Nil(t, CheckGRPC(server))
+
+	healthServer.SetServingStatus("", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	assert.NotNil(t, CheckGRPC(server))
+
+	assert.NotNil(t, CheckGRPC(newServer(t, "http://127.0.0.1:1", fastHealthCheck("/"))))
+}
+
+func TestNewCheckerWithInvalidOption(t *testing.T) {
+	_, err := NewChecker(WithHealthyThreshold(0))
+	assert.NotNil(t, err)
+}
+
+func TestCheckerFiltersUnhealthyServers(t *testing.T) {
+	var healthy int32 = 1
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&healthy) == 1 {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	c, err := NewChecker(WithHealthyThreshold(1))
+	assert.Nil(t, err)
+	defer c.Stop()
+
+	servers := []*services.Server{newServer(t, ts.URL, fastHealthCheck("/"))}
+
+	// unknown servers are healthy
+	assert.Len(t, c.FilterServers(servers), 1)
+
+	atomic.StoreInt32(&healthy, 0)
+	waitFor(t, func() bool { return !c.IsHealthy(ts.URL) })
+	assert.Len(t, c.FilterServers(servers), 0)
+
+	atomic.StoreInt32(&healthy, 1)
+	waitFor(t, func() bool { return c.IsHealthy(ts.URL) })
+	assert.Len(t, c.FilterServers(servers), 1)
+}
+
+func TestCheckerRefreshesTargetSettings(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer ts.Close()
+
+	c, err := NewChecker(WithHealthyThreshold(1))
+	assert.Nil(t, err)
+	defer c.Stop()
+
+	healthCheck := fastHealthCheck("/")
+	healthCheck.Timeout = time.Millisecond
+
+	c.FilterServers([]*services.Server{newServer(t, ts.URL, healthCheck)})
+	waitFor(t, func() bool { return !c.IsHealthy(ts.URL) })
+
+	// the rediscovered server has a longer timeout
+	c.FilterServers([]*services.Server{newServer(t, ts.URL, fastHealthCheck("/"))})
+	waitFor(t, func() bool { return c.IsHealthy(ts.URL) })
+}
+
+func TestCheckerExpiresTargets(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	c, err := NewChecker(WithTargetExpiry(10 * time.Millisecond))
+	assert.Nil(t, err)
+	defer c.Stop()
+
+	c.FilterServers([]*services.Server{newServer(t, ts.URL, fastHealthCheck("/"))})
+
+	waitFor(t, func() bool {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		return len(c.targets) == 0
+	})
+}
@@ -1,6 +1,7 @@
 package infra
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -8,6 +9,8 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ecs"
 	"github.com/spf13/viper"
+
+	"github.com/off-sync/platform-proxy-aws/interfaces"
 )
 
 // Configuration keys.
@@ -48,26 +51,74 @@ func NewAwsEcsSdk(ecsSvc *ecs.ECS, clusterName string) (*AwsEcsSdk, error) {
 	}, nil
 }
 
+// ClusterName returns the name of the current cluster.
+func (s *AwsEcsSdk) ClusterName() string {
+	return aws.StringValue(s.cluster.ClusterName)
+}
+
+// CheckCluster checks that the current cluster can be described using the
+// provided context and is active.
+func (s *AwsEcsSdk) CheckCluster(ctx context.Context) error {
+	clusters, err := s.ecsSvc.DescribeClustersWithContext(ctx, &ecs.DescribeClustersInput{
+		Clusters: []*string{s.cluster.ClusterName},
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(clusters.Failures) > 0 {
+		return fmt.Errorf("checking cluster: %s", aws.StringValue(clusters.Failures[0].Reason))
+	}
+
+	if len(clusters.Clusters) < 1 {
+		return fmt.Errorf("cluster not found: %s", s.ClusterName())
+	}
+
+	if status := aws.StringValue(clusters.Clusters[0].Status); status != "ACTIVE" {
+		return fmt.Errorf("cluster not active: %s", status)
+	}
+
+	return nil
+}
+
 // ListServices returns the service arns of the current cluster.
 func (s *AwsEcsSdk) ListServices() ([]string, error) {
-	var serviceNames []string
+	return s.ListServicesWithContext(context.Background())
+}
+
+// ListServicesWithContext returns the service arns of the current cluster.
+func (s *AwsEcsSdk) ListServicesWithContext(ctx context.Context) ([]string, error) {
+	return interfaces.ListAllServices(ctx, s)
+}
 
-	err := s.ecsSvc.ListServicesPages(&ecs.ListServicesInput{
+// ListServicesPageWithContext returns a single page of the service arns of the
+// current cluster.
+func (s *AwsEcsSdk) ListServicesPageWithContext(ctx context.Context, token string) ([]string, string, error) {
+	input := &ecs.ListServicesInput{
 		Cluster: s.cluster.ClusterName,
-	}, func(output *ecs.ListServicesOutput, lastPage bool) bool {
-		serviceNames = append(serviceNames, aws.StringValueSlice(output.ServiceArns)...)
-		return true
-	})
+	}
+
+	if token != "" {
+		input.NextToken = aws.String(token)
+	}
+
+	output, err := s.ecsSvc.ListServicesWithContext(ctx, input)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	return serviceNames, nil
+	return aws.StringValueSlice(output.ServiceArns), aws.StringValue(output.NextToken), nil
 }
 
 // DescribeService returns the service description for a single service.
 func (s *AwsEcsSdk) DescribeService(serviceArn string) (*ecs.Service, error) {
-	serviceDescription, err := s.ecsSvc.DescribeServices(&ecs.DescribeServicesInput{
+	return s.DescribeServiceWithContext(context.Background(), serviceArn)
+}
+
+// DescribeServiceWithContext returns the service description for a single
+// service.
+func (s *AwsEcsSdk) DescribeServiceWithContext(ctx context.Context, serviceArn string) (*ecs.Service, error) {
+	serviceDescription, err := s.ecsSvc.DescribeServicesWithContext(ctx, &ecs.DescribeServicesInput{
 		Cluster:  s.cluster.ClusterName,
 		Services: aws.StringSlice([]string{serviceArn}),
 	})
@@ -76,7 +127,7 @@ func (s *AwsEcsSdk) DescribeService(serviceArn string) (*ecs.Service, error) {
 	}
 
 	if len(serviceDescription.Services) < 1 {
-		return nil, fmt.Errorf("service not found: %s", serviceArn)
+		return nil, interfaces.ErrServiceNotFound
 	}
 
 	return serviceDescription.Services[0], nil
@@ -84,7 +135,13 @@ func (s *AwsEcsSdk) DescribeService(serviceArn string) (*ecs.Service, error) {
 
 // DescribeTaskDefinition returns the task definition for the provided arn.
 func (s *AwsEcsSdk) DescribeTaskDefinition(taskDefArn string) (*ecs.TaskDefinition, error) {
-	tdef, err := s.ecsSvc.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{
+	return s.DescribeTaskDefinitionWithContext(context.Background(), taskDefArn)
+}
+
+// DescribeTaskDefinitionWithContext returns the task definition for the
+// provided arn.
+func (s *AwsEcsSdk) DescribeTaskDefinitionWithContext(ctx context.Context, taskDefArn string) (*ecs.TaskDefinition, error) {
+	tdef, err := s.ecsSvc.DescribeTaskDefinitionWithContext(ctx, &ecs.DescribeTaskDefinitionInput{
 		TaskDefinition: aws.String(taskDefArn),
 	})
 	if err != nil {
@@ -96,8 +153,9 @@ func (s *AwsEcsSdk) DescribeTaskDefinition(taskDefArn string) (*ecs.TaskDefiniti
 
 // NewAwsEcsSdkFromConfig creates a new AwsEcsSdk using the configuration
 // exposed via viper. The AWS ID, secret, region and cluster name are retrieved
-// from the configuration.
-func NewAwsEcsSdkFromConfig() (*AwsEcsSdk, error) {
+// from the configuration. The provided configs are merged into the ECS client
+// configuration, e.g. to disable the retries of the SDK.
+func NewAwsEcsSdkFromConfig(configs ...*aws.Config) (*AwsEcsSdk, error) {
 	sess, err := session.NewSession(&aws.Config{
 		Credentials: credentials.NewStaticCredentials(viper.GetString(awsID), viper.GetString(awsSecret), ""),
 		Region:      aws.String(viper.GetString(awsRegion)),
@@ -106,7 +164,7 @@ func NewAwsEcsSdkFromConfig() (*AwsEcsSdk, error) {
 		return nil, err
 	}
 
-	ecsSvc := ecs.New(sess)
+	ecsSvc := ecs.New(sess, configs...)
 
 	return NewAwsEcsSdk(ecsSvc, viper.GetString(ecsClusterName))
 }
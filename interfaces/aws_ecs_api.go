@@ -1,6 +1,7 @@
 package interfaces
 
 import (
+	"context"
 	"errors"
 
 	"github.com/aws/aws-sdk-go/service/ecs"
@@ -12,7 +13,9 @@ var (
 	ErrTaskDefinitionNotFound = errors.New("task definition not found")
 )
 
-// AwsEcsAPI abstracts the use of the AWS ECS API.
+// AwsEcsAPI abstracts the use of the AWS ECS API. The methods without a
+// context are equivalent to their WithContext variants called with
+// context.Background().
 type AwsEcsAPI interface {
 	// ListServices returns the service arns of the current cluster.
 	ListServices() ([]string, error)
@@ -24,4 +27,45 @@ type AwsEcsAPI interface {
 	// DescribeTaskDefinition returns the task definition for the provided arn.
 	// Returns ErrTaskDefinitionNotFound if the task definition is not found.
 	DescribeTaskDefinition(taskDefArn string) (*ecs.TaskDefinition, error)
+
+	// ListServicesWithContext is ListServices using the provided context.
+	ListServicesWithContext(ctx context.Context) ([]string, error)
+
+	// ListServicesPageWithContext returns a single page of the service arns
+	// of the current cluster, starting at the provided page token, and the
+	// token of the next page. The first page is requested with an empty token
+	// and the last page has an empty next token.
+	ListServicesPageWithContext(ctx context.Context, token string) ([]string, string, error)
+
+	// DescribeServiceWithContext is DescribeService using the provided
+	// context.
+	DescribeServiceWithContext(ctx context.Context, serviceArn string) (*ecs.Service, error)
+
+	// DescribeTaskDefinitionWithContext is DescribeTaskDefinition using the
+	// provided context.
+	DescribeTaskDefinitionWithContext(ctx context.Context, taskDefArn string) (*ecs.TaskDefinition, error)
+}
+
+// ListAllServices returns the service arns of all pages, requesting every page
+// using ListServicesPageWithContext of the provided API. Implementations of
+// ListServicesWithContext use it to list the services page by page, so that
+// the decorators of an API handle every page as a separate call.
+func ListAllServices(ctx context.Context, api AwsEcsAPI) ([]string, error) {
+	var serviceNames []string
+	var token string
+
+	for {
+		page, next, err := api.ListServicesPageWithContext(ctx, token)
+		if err != nil {
+			return nil, err
+		}
+
+		serviceNames = append(serviceNames, page...)
+
+		if next == "" {
+			return serviceNames, nil
+		}
+
+		token = next
+	}
 }
@@ -1,20 +1,46 @@
 package interfaces
 
 import (
+	"context"
 	"fmt"
+	"strconv"
+	"sync"
 
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/ecs"
 )
 
 // AwsEcsAPIMock mocks the AWS ECS API by providing flags that determine
 // whether method calls always fail, and exposing the various return values
-// in public members of the struct.
+// in public members of the struct. Its methods may be called concurrently.
+// The WithContext methods fail with the error of the context once it is done.
 type AwsEcsAPIMock struct {
+	mutex sync.Mutex
+
 	// Flags that determine whether an error will always be returned.
 	FailListServices           bool
 	FailDescribeService        bool
 	FailDescribeTaskDefinition bool
 
+	// Number of times method calls fail with TransientError before they
+	// succeed.
+	ListServicesFailures           int
+	DescribeServiceFailures        int
+	DescribeTaskDefinitionFailures int
+
+	// TransientError is returned by calls failing because of the numbers of
+	// failures above. Defaults to a throttling error.
+	TransientError error
+
+	// Number of method calls.
+	ListServicesCalls           int
+	DescribeServiceCalls        int
+	DescribeTaskDefinitionCalls int
+
+	// Number of service arns per page of ListServices. Zero returns all
+	// service arns in a single page.
+	ServicesPageSize int
+
 	// Return values.
 	ServiceNames []string
 	Services     map[string]*ecs.Service
@@ -32,15 +58,80 @@ func NewAwsEcsAPIMock() *AwsEcsAPIMock {
 
 // ListServices returns the service arns of the current cluster.
 func (m *AwsEcsAPIMock) ListServices() ([]string, error) {
+	return m.ListServicesWithContext(context.Background())
+}
+
+// ListServicesWithContext returns the service arns of the current cluster.
+func (m *AwsEcsAPIMock) ListServicesWithContext(ctx context.Context) ([]string, error) {
+	return ListAllServices(ctx, m)
+}
+
+// ListServicesPageWithContext returns a single page of the service arns of the
+// current cluster, with ServicesPageSize services per page. ListServicesCalls
+// counts the requests of the first page.
+func (m *AwsEcsAPIMock) ListServicesPageWithContext(ctx context.Context, token string) ([]string, string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if token == "" {
+		m.ListServicesCalls++
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+
+	if m.transientFailure(&m.ListServicesFailures) {
+		return nil, "", m.transientError()
+	}
+
 	if m.FailListServices {
-		return nil, fmt.Errorf("%+v.ListServices()", m)
+		return nil, "", fmt.Errorf("%+v.ListServices()", m)
+	}
+
+	if m.ServicesPageSize < 1 {
+		return m.ServiceNames, "", nil
 	}
 
-	return m.ServiceNames, nil
+	start := 0
+	if token != "" {
+		var err error
+
+		start, err = strconv.Atoi(token)
+		if err != nil || start < 0 || start > len(m.ServiceNames) {
+			return nil, "", fmt.Errorf("invalid page token: %s", token)
+		}
+	}
+
+	end := start + m.ServicesPageSize
+	if end >= len(m.ServiceNames) {
+		return m.ServiceNames[start:], "", nil
+	}
+
+	return m.ServiceNames[start:end], strconv.Itoa(end), nil
 }
 
 // DescribeService returns the service description for a single service.
 func (m *AwsEcsAPIMock) DescribeService(serviceArn string) (*ecs.Service, error) {
+	return m.DescribeServiceWithContext(context.Background(), serviceArn)
+}
+
+// DescribeServiceWithContext returns the service description for a single
+// service.
+func (m *AwsEcsAPIMock) DescribeServiceWithContext(ctx context.Context, serviceArn string) (*ecs.Service, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.DescribeServiceCalls++
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if m.transientFailure(&m.DescribeServiceFailures) {
+		return nil, m.transientError()
+	}
+
 	if m.FailDescribeService {
 		return nil, fmt.Errorf("%+v.DescribeService(%s)", m, serviceArn)
 	}
@@ -55,6 +146,25 @@ func (m *AwsEcsAPIMock) DescribeService(serviceArn string) (*ecs.Service, error)
 
 // DescribeTaskDefinition returns the task definition for the provided arn.
 func (m *AwsEcsAPIMock) DescribeTaskDefinition(taskDefArn string) (*ecs.TaskDefinition, error) {
+	return m.DescribeTaskDefinitionWithContext(context.Background(), taskDefArn)
+}
+
+// DescribeTaskDefinitionWithContext returns the task definition for the
+// provided arn.
+func (m *AwsEcsAPIMock) DescribeTaskDefinitionWithContext(ctx context.Context, taskDefArn string) (*ecs.TaskDefinition, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.DescribeTaskDefinitionCalls++
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if m.transientFailure(&m.DescribeTaskDefinitionFailures) {
+		return nil, m.transientError()
+	}
+
 	if m.FailDescribeTaskDefinition {
 		return nil, fmt.Errorf("%+v.DescribeTaskDefinition(%s)", m, taskDefArn)
 	}
@@ -66,3 +176,23 @@ func (m *AwsEcsAPIMock) DescribeTaskDefinition(taskDefArn string) (*ecs.TaskDefi
 
 	return s, nil
 }
+
+// transientFailure returns whether the call should fail, decrementing the
+// number of failures if so.
+func (m *AwsEcsAPIMock) transientFailure(failures *int) bool {
+	if *failures < 1 {
+		return false
+	}
+
+	*failures--
+
+	return true
+}
+
+func (m *AwsEcsAPIMock) transientError() error {
+	if m.TransientError != nil {
+		return m.TransientError
+	}
+
+	return awserr.New("ThrottlingException", "Rate exceeded", nil)
+}
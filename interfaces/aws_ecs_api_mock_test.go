@@ -1,6 +1,7 @@
 package interfaces
 
 import (
+	"context"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/service/ecs"
@@ -29,6 +30,30 @@ func TestAwsEcsAPIMockFails(t *testing.T) {
 	assert.NotNil(t, err)
 }
 
+func TestAwsEcsAPIMockRespectsCancellation(t *testing.T) {
+	m := NewAwsEcsAPIMock()
+	m.ServiceNames = []string{"web"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	names, err := m.ListServicesWithContext(ctx)
+	assert.Nil(t, err)
+	assert.EqualValues(t, []string{"web"}, names)
+
+	cancel()
+
+	_, err = m.ListServicesWithContext(ctx)
+	assert.Equal(t, context.Canceled, err)
+
+	_, err = m.DescribeServiceWithContext(ctx, "serviceArn")
+	assert.Equal(t, context.Canceled, err)
+
+	_, err = m.DescribeTaskDefinitionWithContext(ctx, "taskDefArn")
+	assert.Equal(t, context.Canceled, err)
+
+	assert.Equal(t, 2, m.ListServicesCalls)
+}
+
 func TestAwsEcsAPIMockReturnsCorrectErrorOnNotFound(t *testing.T) {
 	m := NewAwsEcsAPIMock()
 
@@ -62,3 +87,40 @@ func TestAwsEcsAPIMockReturnsConfiguredReturnValues(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, expectedTaskDef, taskDef)
 }
+
+func TestAwsEcsAPIMockFailsNTimes(t *testing.T) {
+	m := NewAwsEcsAPIMock()
+	m.ListServicesFailures = 2
+
+	_, err := m.ListServices()
+	assert.NotNil(t, err)
+
+	_, err = m.ListServices()
+	assert.NotNil(t, err)
+
+	_, err = m.ListServices()
+	assert.Nil(t, err)
+
+	assert.Equal(t, 3, m.ListServicesCalls)
+}
+
+func TestAwsEcsAPIMockPagesServices(t *testing.T) {
+	m := NewAwsEcsAPIMock()
+	m.ServiceNames = []string{"web", "api", "admin"}
+	m.ServicesPageSize = 2
+
+	page, next, err := m.ListServicesPageWithContext(context.Background(), "")
+	assert.Nil(t, err)
+	assert.EqualValues(t, []string{"web", "api"}, page)
+	assert.NotEqual(t, "", next)
+
+	page, next, err = m.ListServicesPageWithContext(context.Background(), next)
+	assert.Nil(t, err)
+	assert.EqualValues(t, []string{"admin"}, page)
+	assert.Equal(t, "", next)
+
+	names, err := m.ListServicesWithContext(context.Background())
+	assert.Nil(t, err)
+	assert.EqualValues(t, m.ServiceNames, names)
+	assert.Equal(t, 2, m.ListServicesCalls)
+}
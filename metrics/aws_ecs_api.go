@@ -0,0 +1,168 @@
+// Copyright (c) 2017 off-sync
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package metrics instruments the AWS ECS API and the service repository with
+// Prometheus metrics.
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/off-sync/platform-proxy-aws/interfaces"
+)
+
+// Namespace is the namespace of all metrics.
+const Namespace = "platform_proxy"
+
+// Error codes used for errors that are not AWS errors.
+const (
+	ErrorCodeServiceNotFound        = "ServiceNotFound"
+	ErrorCodeTaskDefinitionNotFound = "TaskDefinitionNotFound"
+	ErrorCodeUnknown                = "Unknown"
+)
+
+// AwsEcsAPI decorates an AwsEcsAPI with metrics on the number of calls, the
+// number of errors by error code and the latency of the calls, all per method.
+type AwsEcsAPI struct {
+	api interfaces.AwsEcsAPI
+
+	calls    *prometheus.CounterVec
+	errors   *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+// NewAwsEcsAPI creates a new AwsEcsAPI decorator and registers its metrics
+// with the provided registerer.
+func NewAwsEcsAPI(api interfaces.AwsEcsAPI, registerer prometheus.Registerer) (*AwsEcsAPI, error) {
+	m := &AwsEcsAPI{
+		api: api,
+		calls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "ecs_api",
+			Name:      "calls_total",
+			Help:      "Number of ECS API calls.",
+		}, []string{"method"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "ecs_api",
+			Name:      "errors_total",
+			Help:      "Number of failed ECS API calls by error code.",
+		}, []string{"method", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Subsystem: "ecs_api",
+			Name:      "call_duration_seconds",
+			Help:      "Latency of ECS API calls.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method"}),
+	}
+
+	for _, c := range []prometheus.Collector{m.calls, m.errors, m.duration} {
+		err := registerer.Register(c)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// ListServices implements the AwsEcsAPI interface.
+func (m *AwsEcsAPI) ListServices() ([]string, error) {
+	return m.ListServicesWithContext(context.Background())
+}
+
+// ListServicesWithContext implements the AwsEcsAPI interface. Every page is
+// observed as a separate call.
+func (m *AwsEcsAPI) ListServicesWithContext(ctx context.Context) ([]string, error) {
+	return interfaces.ListAllServices(ctx, m)
+}
+
+// ListServicesPageWithContext implements the AwsEcsAPI interface.
+func (m *AwsEcsAPI) ListServicesPageWithContext(ctx context.Context, token string) ([]string, string, error) {
+	defer m.observe("ListServices", time.Now())
+
+	names, next, err := m.api.ListServicesPageWithContext(ctx, token)
+	m.countError("ListServices", err)
+
+	return names, next, err
+}
+
+// DescribeService implements the AwsEcsAPI interface.
+func (m *AwsEcsAPI) DescribeService(serviceArn string) (*ecs.Service, error) {
+	return m.DescribeServiceWithContext(context.Background(), serviceArn)
+}
+
+// DescribeServiceWithContext implements the AwsEcsAPI interface.
+func (m *AwsEcsAPI) DescribeServiceWithContext(ctx context.Context, serviceArn string) (*ecs.Service, error) {
+	defer m.observe("DescribeService", time.Now())
+
+	service, err := m.api.DescribeServiceWithContext(ctx, serviceArn)
+	m.countError("DescribeService", err)
+
+	return service, err
+}
+
+// DescribeTaskDefinition implements the AwsEcsAPI interface.
+func (m *AwsEcsAPI) DescribeTaskDefinition(taskDefArn string) (*ecs.TaskDefinition, error) {
+	return m.DescribeTaskDefinitionWithContext(context.Background(), taskDefArn)
+}
+
+// DescribeTaskDefinitionWithContext implements the AwsEcsAPI interface.
+func (m *AwsEcsAPI) DescribeTaskDefinitionWithContext(ctx context.Context, taskDefArn string) (*ecs.TaskDefinition, error) {
+	defer m.observe("DescribeTaskDefinition", time.Now())
+
+	tdef, err := m.api.DescribeTaskDefinitionWithContext(ctx, taskDefArn)
+	m.countError("DescribeTaskDefinition", err)
+
+	return tdef, err
+}
+
+func (m *AwsEcsAPI) observe(method string, start time.Time) {
+	m.calls.WithLabelValues(method).Inc()
+	m.duration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+}
+
+func (m *AwsEcsAPI) countError(method string, err error) {
+	if err != nil {
+		m.errors.WithLabelValues(method, errorCode(err)).Inc()
+	}
+}
+
+// errorCode returns the code of an AWS error, or a code describing the error.
+func errorCode(err error) string {
+	switch err {
+	case interfaces.ErrServiceNotFound:
+		return ErrorCodeServiceNotFound
+	case interfaces.ErrTaskDefinitionNotFound:
+		return ErrorCodeTaskDefinitionNotFound
+	}
+
+	if awsErr, ok := err.(awserr.Error); ok {
+		return awsErr.Code()
+	}
+
+	return ErrorCodeUnknown
+}
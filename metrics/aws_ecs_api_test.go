@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/off-sync/platform-proxy-aws/interfaces"
+)
+
+func TestAwsEcsAPICountsCallsAndErrors(t *testing.T) {
+	api := interfaces.NewAwsEcsAPIMock()
+	api.ServiceNames = []string{"web"}
+
+	m, err := NewAwsEcsAPI(api, prometheus.NewRegistry())
+	assert.Nil(t, err)
+
+	names, err := m.ListServices()
+	assert.Nil(t, err)
+	assert.EqualValues(t, []string{"web"}, names)
+
+	_, err = m.DescribeService("web")
+	assert.Equal(t, interfaces.ErrServiceNotFound, err)
+
+	_, err = m.DescribeTaskDefinition("webTaskDef")
+	assert.Equal(t, interfaces.ErrTaskDefinitionNotFound, err)
+
+	api.FailListServices = true
+	_, err = m.ListServices()
+	assert.NotNil(t, err)
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.calls.WithLabelValues("ListServices")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.calls.WithLabelValues("DescribeService")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.errors.WithLabelValues("ListServices", ErrorCodeUnknown)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.errors.WithLabelValues("DescribeService", ErrorCodeServiceNotFound)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.errors.WithLabelValues("DescribeTaskDefinition", ErrorCodeTaskDefinitionNotFound)))
+	assert.Equal(t, 3, testutil.CollectAndCount(m.duration))
+}
+
+func TestAwsEcsAPIShouldFailOnDuplicateRegistration(t *testing.T) {
+	registry := prometheus.NewRegistry()
+
+	_, err := NewAwsEcsAPI(interfaces.NewAwsEcsAPIMock(), registry)
+	assert.Nil(t, err)
+
+	_, err = NewAwsEcsAPI(interfaces.NewAwsEcsAPIMock(), registry)
+	assert.NotNil(t, err)
+}
+
+func TestErrorCode(t *testing.T) {
+	assert.Equal(t, "ThrottlingException", errorCode(awserr.New("ThrottlingException", "Rate exceeded", nil)))
+	assert.Equal(t, ErrorCodeUnknown, errorCode(errors.New("failed")))
+}
@@ -0,0 +1,193 @@
+// Copyright (c) 2017 off-sync
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package metrics
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/off-sync/platform-proxy-aws/services"
+	domain "github.com/off-sync/platform-proxy-domain/services"
+)
+
+// ServiceRepository decorates a ServiceRepository with gauges for the number
+// of discovered services and servers, and the duration of the discovery.
+// The servers gauges of services that are no longer listed are removed.
+type ServiceRepository struct {
+	*services.ServiceRepository
+
+	services prometheus.Gauge
+	servers  *prometheus.GaugeVec
+	duration *prometheus.HistogramVec
+
+	mutex  sync.Mutex
+	gauged map[string]bool
+}
+
+// NewServiceRepository creates a new ServiceRepository decorator and
+// registers its metrics with the provided registerer.
+func NewServiceRepository(r *services.ServiceRepository, registerer prometheus.Registerer) (*ServiceRepository, error) {
+	m := &ServiceRepository{
+		ServiceRepository: r,
+		services: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Subsystem: "discovery",
+			Name:      "services",
+			Help:      "Number of discovered services.",
+		}),
+		servers: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Subsystem: "discovery",
+			Name:      "servers",
+			Help:      "Number of discovered servers per service.",
+		}, []string{"service"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Subsystem: "discovery",
+			Name:      "duration_seconds",
+			Help:      "Duration of listing and describing services.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"operation"}),
+		gauged: make(map[string]bool),
+	}
+
+	for _, c := range []prometheus.Collector{m.services, m.servers, m.duration} {
+		err := registerer.Register(c)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// ListServices lists the services and updates the services gauge.
+func (m *ServiceRepository) ListServices() ([]string, error) {
+	return m.ListServicesWithContext(context.Background())
+}
+
+// ListServicesWithContext lists the services using the provided context and
+// updates the services gauge. The servers gauges of services that are not
+// listed are removed.
+func (m *ServiceRepository) ListServicesWithContext(ctx context.Context) ([]string, error) {
+	defer m.observe("list", time.Now())
+
+	names, err := m.ServiceRepository.ListServicesWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	m.services.Set(float64(len(names)))
+
+	listed := make(map[string]bool, len(names))
+	for _, name := range names {
+		listed[name] = true
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for name := range m.gauged {
+		if !listed[name] {
+			m.servers.DeleteLabelValues(name)
+			delete(m.gauged, name)
+		}
+	}
+
+	return names, nil
+}
+
+// DescribeService describes the service and updates its servers gauge.
+func (m *ServiceRepository) DescribeService(name string) (*domain.Service, error) {
+	return m.DescribeServiceWithContext(context.Background(), name)
+}
+
+// DescribeServiceWithContext describes the service using the provided context
+// and updates its servers gauge. As the URLs of a domain service are repeated
+// in proportion to their weight, only distinct URLs are counted.
+func (m *ServiceRepository) DescribeServiceWithContext(ctx context.Context, name string) (*domain.Service, error) {
+	defer m.observe("describe", time.Now())
+
+	service, err := m.ServiceRepository.DescribeServiceWithContext(ctx, name)
+	if err != nil {
+		m.deleteServers(name)
+		return nil, err
+	}
+
+	m.setServers(name, service.Servers)
+
+	return service, nil
+}
+
+// DescribeServiceDetails describes the service and updates its servers gauge.
+func (m *ServiceRepository) DescribeServiceDetails(name string) (*services.ServiceDescription, error) {
+	return m.DescribeServiceDetailsWithContext(context.Background(), name)
+}
+
+// DescribeServiceDetailsWithContext describes the service using the provided
+// context and updates its servers gauge. Only distinct URLs are counted, like
+// DescribeServiceWithContext does.
+func (m *ServiceRepository) DescribeServiceDetailsWithContext(ctx context.Context, name string) (*services.ServiceDescription, error) {
+	defer m.observe("describe", time.Now())
+
+	desc, err := m.ServiceRepository.DescribeServiceDetailsWithContext(ctx, name)
+	if err != nil {
+		m.deleteServers(name)
+		return nil, err
+	}
+
+	m.setServers(name, desc.URLs())
+
+	return desc, nil
+}
+
+// setServers sets the servers gauge of the service to the number of distinct
+// URLs.
+func (m *ServiceRepository) setServers(name string, urls []*url.URL) {
+	distinct := make(map[string]bool, len(urls))
+
+	for _, u := range urls {
+		distinct[u.String()] = true
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.servers.WithLabelValues(name).Set(float64(len(distinct)))
+	m.gauged[name] = true
+}
+
+// deleteServers removes the servers gauge of the service.
+func (m *ServiceRepository) deleteServers(name string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.servers.DeleteLabelValues(name)
+	delete(m.gauged, name)
+}
+
+func (m *ServiceRepository) observe(operation string, start time.Time) {
+	m.duration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,138 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/off-sync/platform-proxy-aws/interfaces"
+	"github.com/off-sync/platform-proxy-aws/services"
+)
+
+func TestServiceRepositoryGauges(t *testing.T) {
+	api := interfaces.NewAwsEcsAPIMock()
+	api.ServiceNames = []string{"web"}
+	api.Services["web"] = &ecs.Service{
+		ServiceName:    aws.String("web"),
+		TaskDefinition: aws.String("webTaskDef"),
+	}
+	api.TaskDefs["webTaskDef"] = &ecs.TaskDefinition{
+		ContainerDefinitions: []*ecs.ContainerDefinition{
+			&ecs.ContainerDefinition{
+				Name:     aws.String(services.DefaultServerContainerName),
+				Hostname: aws.String("web"),
+			},
+		},
+	}
+
+	r, err := services.NewServiceRepository(api)
+	assert.Nil(t, err)
+
+	m, err := NewServiceRepository(r, prometheus.NewRegistry())
+	assert.Nil(t, err)
+
+	_, err = m.ListServices()
+	assert.Nil(t, err)
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.services))
+
+	_, err = m.DescribeService("web")
+	assert.Nil(t, err)
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.servers.WithLabelValues("web")))
+
+	// the gauge of a service that can no longer be described is removed
+	api.FailDescribeService = true
+	_, err = m.DescribeServiceDetails("web")
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, testutil.CollectAndCount(m.servers))
+
+	assert.Equal(t, 2, testutil.CollectAndCount(m.duration))
+}
+
+func TestServiceRepositoryShouldRemoveGaugesOfUnlistedServices(t *testing.T) {
+	api := interfaces.NewAwsEcsAPIMock()
+
+	for _, name := range []string{"web", "api"} {
+		api.ServiceNames = append(api.ServiceNames, name)
+		api.Services[name] = &ecs.Service{
+			ServiceName:    aws.String(name),
+			TaskDefinition: aws.String(name + "TaskDef"),
+		}
+		api.TaskDefs[name+"TaskDef"] = &ecs.TaskDefinition{
+			ContainerDefinitions: []*ecs.ContainerDefinition{
+				&ecs.ContainerDefinition{
+					Name:     aws.String(services.DefaultServerContainerName),
+					Hostname: aws.String(name),
+				},
+			},
+		}
+	}
+
+	r, err := services.NewServiceRepository(api)
+	assert.Nil(t, err)
+
+	m, err := NewServiceRepository(r, prometheus.NewRegistry())
+	assert.Nil(t, err)
+
+	for _, name := range api.ServiceNames {
+		_, err = m.DescribeServiceDetails(name)
+		assert.Nil(t, err)
+	}
+
+	assert.Equal(t, 2, testutil.CollectAndCount(m.servers))
+
+	// the api service is deleted
+	api.ServiceNames = []string{"web"}
+
+	_, err = m.ListServices()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, testutil.CollectAndCount(m.servers))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.servers.WithLabelValues("web")))
+}
+
+func TestServiceRepositoryGaugeCountsDistinctServers(t *testing.T) {
+	api := interfaces.NewAwsEcsAPIMock()
+
+	for _, name := range []string{"web", "web-canary"} {
+		labels := map[string]*string{}
+		if name == "web-canary" {
+			labels[services.DefaultDockerLabelCanaryOf] = aws.String("web")
+			labels[services.DefaultDockerLabelWeight] = aws.String("10")
+		}
+
+		api.ServiceNames = append(api.ServiceNames, name)
+		api.Services[name] = &ecs.Service{
+			ServiceName:    aws.String(name),
+			TaskDefinition: aws.String(name + "TaskDef"),
+		}
+		api.TaskDefs[name+"TaskDef"] = &ecs.TaskDefinition{
+			ContainerDefinitions: []*ecs.ContainerDefinition{
+				&ecs.ContainerDefinition{
+					Name:         aws.String(services.DefaultServerContainerName),
+					Hostname:     aws.String(name),
+					DockerLabels: labels,
+				},
+			},
+		}
+	}
+
+	r, err := services.NewServiceRepository(api, services.WithCanaryGrouping(true))
+	assert.Nil(t, err)
+
+	m, err := NewServiceRepository(r, prometheus.NewRegistry())
+	assert.Nil(t, err)
+
+	service, err := m.DescribeService("web")
+	assert.Nil(t, err)
+
+	// the URLs are repeated by weight, the gauge counts the servers
+	assert.True(t, len(service.Servers) > 2)
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.servers.WithLabelValues("web")))
+
+	_, err = m.DescribeServiceDetails("web")
+	assert.Nil(t, err)
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.servers.WithLabelValues("web")))
+}
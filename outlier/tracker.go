@@ -0,0 +1,239 @@
+// Copyright (c) 2017 off-sync
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package outlier implements passive outlier detection: servers that return
+// repeated errors are temporarily ejected from the servers of a service.
+package outlier
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/off-sync/platform-proxy-aws/services"
+)
+
+// Default values for the Tracker struct.
+const (
+	DefaultConsecutiveErrors = 5
+	DefaultEjectionTime      = 30 * time.Second
+	DefaultServerExpiry      = 5 * time.Minute
+)
+
+// Tracker keeps track of the outcome of requests to servers and ejects
+// servers after a number of consecutive errors for the ejection time. A
+// request fails if it returns an error or a 5xx status code. Only servers
+// passed to FilterServers are tracked, outcomes of requests to other hosts
+// are ignored. Servers that have not been passed to FilterServers for the
+// server expiry are forgotten.
+//
+// Tracker implements the services.ServerFilter interface.
+type Tracker struct {
+	consecutiveErrors int
+	ejectionTime      time.Duration
+	serverExpiry      time.Duration
+
+	// now returns the current time, it is replaced in tests.
+	now func() time.Time
+
+	mutex   sync.Mutex
+	servers map[string]*serverState
+}
+
+type serverState struct {
+	errors       int
+	ejectedUntil time.Time
+	lastSeen     time.Time
+}
+
+// TrackerOption defines the type used to further configure a Tracker.
+type TrackerOption func(*Tracker) error
+
+// NewTracker creates a new outlier tracker.
+func NewTracker(options ...TrackerOption) (*Tracker, error) {
+	t := &Tracker{
+		consecutiveErrors: DefaultConsecutiveErrors,
+		ejectionTime:      DefaultEjectionTime,
+		serverExpiry:      DefaultServerExpiry,
+		now:               time.Now,
+		servers:           make(map[string]*serverState),
+	}
+
+	for _, opt := range options {
+		err := opt(t)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return t, nil
+}
+
+// WithConsecutiveErrors configures a tracker with the provided number of
+// consecutive errors after which a server is ejected.
+func WithConsecutiveErrors(n int) TrackerOption {
+	return func(t *Tracker) error {
+		if n < 1 {
+			return fmt.Errorf("invalid consecutive errors: %d", n)
+		}
+
+		t.consecutiveErrors = n
+		return nil
+	}
+}
+
+// WithEjectionTime configures a tracker with the provided duration for which
+// servers are ejected.
+func WithEjectionTime(d time.Duration) TrackerOption {
+	return func(t *Tracker) error {
+		if d <= 0 {
+			return fmt.Errorf("invalid ejection time: %s", d)
+		}
+
+		t.ejectionTime = d
+		return nil
+	}
+}
+
+// WithServerExpiry configures a tracker with the provided duration after which
+// servers that have not been passed to FilterServers are forgotten.
+func WithServerExpiry(d time.Duration) TrackerOption {
+	return func(t *Tracker) error {
+		if d <= 0 {
+			return fmt.Errorf("invalid server expiry: %s", d)
+		}
+
+		t.serverExpiry = d
+		return nil
+	}
+}
+
+// Report records the outcome of a request to the server with the provided URL.
+// The status code is ignored if err is not nil.
+func (t *Tracker) Report(serverURL string, statusCode int, err error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.prune()
+
+	s, found := t.servers[serverURL]
+	if !found {
+		// not a discovered server
+		return
+	}
+
+	if err == nil && statusCode < 500 {
+		// a success resets the consecutive errors
+		s.errors = 0
+		return
+	}
+
+	if t.now().Before(s.ejectedUntil) {
+		// already ejected
+		return
+	}
+
+	s.errors++
+
+	if s.errors >= t.consecutiveErrors {
+		s.errors = 0
+		s.ejectedUntil = t.now().Add(t.ejectionTime)
+	}
+}
+
+// IsEjected returns whether the server with the provided URL is ejected.
+func (t *Tracker) IsEjected(serverURL string) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	s, found := t.servers[serverURL]
+
+	return found && t.now().Before(s.ejectedUntil)
+}
+
+// FilterServers returns the servers that are not ejected.
+func (t *Tracker) FilterServers(servers []*services.Server) []*services.Server {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.prune()
+
+	now := t.now()
+
+	var filtered []*services.Server
+
+	for _, server := range servers {
+		s, found := t.servers[server.URL.String()]
+		if !found {
+			s = &serverState{}
+			t.servers[server.URL.String()] = s
+		}
+
+		s.lastSeen = now
+
+		if !now.Before(s.ejectedUntil) {
+			filtered = append(filtered, server)
+		}
+	}
+
+	return filtered
+}
+
+// prune forgets the servers that have not been seen for the server expiry.
+func (t *Tracker) prune() {
+	now := t.now()
+
+	for serverURL, s := range t.servers {
+		if now.Sub(s.lastSeen) > t.serverExpiry {
+			delete(t.servers, serverURL)
+		}
+	}
+}
+
+// RoundTripper reports the outcome of every request it sends to a tracker.
+type RoundTripper struct {
+	tracker *Tracker
+	next    http.RoundTripper
+}
+
+// NewRoundTripper creates a round tripper that sends requests using next and
+// reports their outcome to the tracker. The server is identified by the
+// scheme and host of the request URL.
+func NewRoundTripper(tracker *Tracker, next http.RoundTripper) *RoundTripper {
+	return &RoundTripper{
+		tracker: tracker,
+		next:    next,
+	}
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (rt *RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := rt.next.RoundTrip(req)
+
+	statusCode := 0
+	if resp != nil {
+		statusCode = resp.StatusCode
+	}
+
+	rt.tracker.Report(req.URL.Scheme+"://"+req.URL.Host, statusCode, err)
+
+	return resp, err
+}
@@ -0,0 +1,136 @@
+package outlier
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/off-sync/platform-proxy-aws/services"
+)
+
+const serverURL = "http://server:8080"
+
+func setUp(t *testing.T, options ...TrackerOption) (*Tracker, *time.Time) {
+	tracker, err := NewTracker(options...)
+	assert.Nil(t, err)
+	assert.NotNil(t, tracker)
+
+	now := time.Now()
+	tracker.now = func() time.Time { return now }
+
+	discover(t, tracker, serverURL)
+
+	return tracker, &now
+}
+
+// discover passes the servers with the provided URLs to the tracker.
+func discover(t *testing.T, tracker *Tracker, serverURLs ...string) []*services.Server {
+	var servers []*services.Server
+
+	for _, serverURL := range serverURLs {
+		u, err := url.Parse(serverURL)
+		assert.Nil(t, err)
+
+		servers = append(servers, &services.Server{URL: u})
+	}
+
+	return tracker.FilterServers(servers)
+}
+
+func TestNewTrackerWithInvalidOptions(t *testing.T) {
+	_, err := NewTracker(WithConsecutiveErrors(0))
+	assert.NotNil(t, err)
+
+	_, err = NewTracker(WithEjectionTime(0))
+	assert.NotNil(t, err)
+
+	_, err = NewTracker(WithServerExpiry(0))
+	assert.NotNil(t, err)
+}
+
+func TestTrackerEjectsAfterConsecutiveErrors(t *testing.T) {
+	tracker, now := setUp(t, WithConsecutiveErrors(3), WithEjectionTime(time.Minute))
+
+	tracker.Report(serverURL, 0, errors.New("connection refused"))
+	tracker.Report(serverURL, http.StatusBadGateway, nil)
+	assert.False(t, tracker.IsEjected(serverURL))
+
+	tracker.Report(serverURL, http.StatusInternalServerError, nil)
+	assert.True(t, tracker.IsEjected(serverURL))
+
+	*now = now.Add(time.Minute)
+	assert.False(t, tracker.IsEjected(serverURL))
+}
+
+func TestTrackerSuccessResetsErrors(t *testing.T) {
+	tracker, _ := setUp(t, WithConsecutiveErrors(2))
+
+	tracker.Report(serverURL, http.StatusServiceUnavailable, nil)
+	tracker.Report(serverURL, http.StatusNotFound, nil)
+	tracker.Report(serverURL, http.StatusServiceUnavailable, nil)
+	assert.False(t, tracker.IsEjected(serverURL))
+}
+
+func TestTrackerFilterServers(t *testing.T) {
+	tracker, _ := setUp(t, WithConsecutiveErrors(1))
+
+	discover(t, tracker, serverURL, "http://other:8080")
+
+	tracker.Report(serverURL, 0, errors.New("timeout"))
+
+	servers := discover(t, tracker, serverURL, "http://other:8080")
+
+	if assert.Len(t, servers, 1) {
+		assert.Equal(t, "http://other:8080", servers[0].URL.String())
+	}
+}
+
+func TestTrackerIgnoresUndiscoveredServers(t *testing.T) {
+	tracker, _ := setUp(t, WithConsecutiveErrors(1))
+
+	tracker.Report("https://ecs.eu-west-1.amazonaws.com", 0, errors.New("timeout"))
+	assert.False(t, tracker.IsEjected("https://ecs.eu-west-1.amazonaws.com"))
+	assert.Len(t, tracker.servers, 1)
+}
+
+func TestTrackerForgetsUndiscoveredServers(t *testing.T) {
+	tracker, now := setUp(t, WithConsecutiveErrors(1), WithEjectionTime(time.Hour), WithServerExpiry(time.Minute))
+
+	discover(t, tracker, "http://gone:8080")
+
+	tracker.Report(serverURL, 0, errors.New("timeout"))
+	tracker.Report("http://gone:8080", 0, errors.New("timeout"))
+
+	*now = now.Add(45 * time.Second)
+	assert.Len(t, discover(t, tracker, serverURL), 0)
+
+	*now = now.Add(45 * time.Second)
+	assert.Len(t, discover(t, tracker, serverURL), 0)
+
+	assert.Len(t, tracker.servers, 1)
+	assert.True(t, tracker.IsEjected(serverURL))
+	assert.False(t, tracker.IsEjected("http://gone:8080"))
+}
+
+func TestRoundTripperReportsOutcome(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	tracker, _ := setUp(t, WithConsecutiveErrors(1))
+	discover(t, tracker, ts.URL)
+
+	client := &http.Client{Transport: NewRoundTripper(tracker, http.DefaultTransport)}
+
+	resp, err := client.Get(ts.URL + "/path")
+	assert.Nil(t, err)
+	resp.Body.Close()
+
+	assert.True(t, tracker.IsEjected(ts.URL))
+}
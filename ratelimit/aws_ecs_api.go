@@ -0,0 +1,176 @@
+// Copyright (c) 2017 off-sync
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package ratelimit limits the rate of AWS ECS API calls, so that the proxy
+// leaves part of the ECS API quota of the account to other clients.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"golang.org/x/time/rate"
+
+	"github.com/off-sync/platform-proxy-aws/interfaces"
+)
+
+// Default values for the AwsEcsAPI struct.
+const (
+	DefaultRate  = 10
+	DefaultBurst = 20
+)
+
+// Methods of the AwsEcsAPI interface.
+const (
+	MethodListServices           = "ListServices"
+	MethodDescribeService        = "DescribeService"
+	MethodDescribeTaskDefinition = "DescribeTaskDefinition"
+)
+
+// Methods lists the methods of the AwsEcsAPI interface.
+var Methods = []string{
+	MethodListServices,
+	MethodDescribeService,
+	MethodDescribeTaskDefinition,
+}
+
+// AwsEcsAPI decorates an AwsEcsAPI with a token bucket rate limiter per
+// method. Calls exceeding the rate wait for a token, so that discovery slows
+// down instead of exhausting the ECS API quota.
+type AwsEcsAPI struct {
+	api      interfaces.AwsEcsAPI
+	limiters map[string]*rate.Limiter
+}
+
+// AwsEcsAPIOption defines the type used to further configure an AwsEcsAPI.
+type AwsEcsAPIOption func(*AwsEcsAPI) error
+
+// NewAwsEcsAPI creates a new rate limiting AwsEcsAPI decorator. Every method
+// is limited to DefaultRate calls per second with a burst of DefaultBurst
+// calls, unless configured otherwise.
+func NewAwsEcsAPI(api interfaces.AwsEcsAPI, options ...AwsEcsAPIOption) (*AwsEcsAPI, error) {
+	l := &AwsEcsAPI{
+		api:      api,
+		limiters: make(map[string]*rate.Limiter),
+	}
+
+	for _, method := range Methods {
+		l.limiters[method] = rate.NewLimiter(DefaultRate, DefaultBurst)
+	}
+
+	for _, opt := range options {
+		err := opt(l)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return l, nil
+}
+
+// WithLimit configures the rate, in calls per second, and the burst of all
+// methods.
+func WithLimit(r float64, burst int) AwsEcsAPIOption {
+	return func(l *AwsEcsAPI) error {
+		for _, method := range Methods {
+			err := WithMethodLimit(method, r, burst)(l)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// WithMethodLimit configures the rate, in calls per second, and the burst of
+// a single method.
+func WithMethodLimit(method string, r float64, burst int) AwsEcsAPIOption {
+	return func(l *AwsEcsAPI) error {
+		if _, found := l.limiters[method]; !found {
+			return fmt.Errorf("unknown method: %s", method)
+		}
+
+		if r <= 0 || burst < 1 {
+			return fmt.Errorf("invalid rate limit for %s: %g/s, burst %d", method, r, burst)
+		}
+
+		l.limiters[method] = rate.NewLimiter(rate.Limit(r), burst)
+		return nil
+	}
+}
+
+// ListServices implements the AwsEcsAPI interface.
+func (l *AwsEcsAPI) ListServices() ([]string, error) {
+	return l.ListServicesWithContext(context.Background())
+}
+
+// ListServicesWithContext implements the AwsEcsAPI interface. Every page
+// takes a token, as every page is a separate ECS API call.
+func (l *AwsEcsAPI) ListServicesWithContext(ctx context.Context) ([]string, error) {
+	return interfaces.ListAllServices(ctx, l)
+}
+
+// ListServicesPageWithContext implements the AwsEcsAPI interface.
+func (l *AwsEcsAPI) ListServicesPageWithContext(ctx context.Context, token string) ([]string, string, error) {
+	err := l.wait(ctx, MethodListServices)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return l.api.ListServicesPageWithContext(ctx, token)
+}
+
+// DescribeService implements the AwsEcsAPI interface.
+func (l *AwsEcsAPI) DescribeService(serviceArn string) (*ecs.Service, error) {
+	return l.DescribeServiceWithContext(context.Background(), serviceArn)
+}
+
+// DescribeServiceWithContext implements the AwsEcsAPI interface.
+func (l *AwsEcsAPI) DescribeServiceWithContext(ctx context.Context, serviceArn string) (*ecs.Service, error) {
+	err := l.wait(ctx, MethodDescribeService)
+	if err != nil {
+		return nil, err
+	}
+
+	return l.api.DescribeServiceWithContext(ctx, serviceArn)
+}
+
+// DescribeTaskDefinition implements the AwsEcsAPI interface.
+func (l *AwsEcsAPI) DescribeTaskDefinition(taskDefArn string) (*ecs.TaskDefinition, error) {
+	return l.DescribeTaskDefinitionWithContext(context.Background(), taskDefArn)
+}
+
+// DescribeTaskDefinitionWithContext implements the AwsEcsAPI interface.
+func (l *AwsEcsAPI) DescribeTaskDefinitionWithContext(ctx context.Context, taskDefArn string) (*ecs.TaskDefinition, error) {
+	err := l.wait(ctx, MethodDescribeTaskDefinition)
+	if err != nil {
+		return nil, err
+	}
+
+	return l.api.DescribeTaskDefinitionWithContext(ctx, taskDefArn)
+}
+
+// wait waits for a token of the method, failing if the context is done
+// first.
+func (l *AwsEcsAPI) wait(ctx context.Context, method string) error {
+	return l.limiters[method].Wait(ctx)
+}
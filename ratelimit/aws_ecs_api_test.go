@@ -0,0 +1,84 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/off-sync/platform-proxy-aws/interfaces"
+)
+
+func TestRateLimitPerMethod(t *testing.T) {
+	api := interfaces.NewAwsEcsAPIMock()
+
+	l, err := NewAwsEcsAPI(api, WithMethodLimit(MethodListServices, 20, 2))
+	assert.Nil(t, err)
+
+	// the burst is available immediately, the third call waits for a token
+	for i := 0; i < 2; i++ {
+		_, err = l.ListServices()
+		assert.Nil(t, err)
+	}
+
+	assert.True(t, l.limiters[MethodListServices].Tokens() < 1)
+
+	_, err = l.ListServices()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, api.ListServicesCalls)
+
+	// other methods have their own bucket
+	assert.Equal(t, float64(DefaultBurst), l.limiters[MethodDescribeService].Tokens())
+
+	for i := 0; i < DefaultBurst; i++ {
+		l.DescribeService("web")
+	}
+
+	assert.Equal(t, DefaultBurst, api.DescribeServiceCalls)
+}
+
+func TestRateLimitPerPage(t *testing.T) {
+	api := interfaces.NewAwsEcsAPIMock()
+	api.ServiceNames = []string{"web", "api", "admin"}
+	api.ServicesPageSize = 1
+
+	l, err := NewAwsEcsAPI(api, WithLimit(0.001, 3))
+	assert.Nil(t, err)
+
+	names, err := l.ListServices()
+	assert.Nil(t, err)
+	assert.EqualValues(t, api.ServiceNames, names)
+
+	// every page takes a token
+	assert.True(t, l.limiters[MethodListServices].Tokens() < 1)
+}
+
+func TestRateLimitShouldStopWaitingWhenContextIsDone(t *testing.T) {
+	api := interfaces.NewAwsEcsAPIMock()
+
+	l, err := NewAwsEcsAPI(api, WithLimit(1, 1))
+	assert.Nil(t, err)
+
+	_, err = l.ListServices()
+	assert.Nil(t, err)
+
+	// the next token is only available after a second
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err = l.ListServicesWithContext(ctx)
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, api.ListServicesCalls)
+}
+
+func TestInvalidLimits(t *testing.T) {
+	_, err := NewAwsEcsAPI(nil, WithMethodLimit("DescribeTasks", 1, 1))
+	assert.NotNil(t, err)
+
+	_, err = NewAwsEcsAPI(nil, WithLimit(0, 1))
+	assert.NotNil(t, err)
+
+	_, err = NewAwsEcsAPI(nil, WithLimit(1, 0))
+	assert.NotNil(t, err)
+}
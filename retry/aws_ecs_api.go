@@ -0,0 +1,290 @@
+// Copyright (c) 2017 off-sync
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package retry retries failed AWS ECS API calls that are throttled or
+// otherwise transient, using exponential backoff with jitter.
+package retry
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ecs"
+
+	"github.com/off-sync/platform-proxy-aws/interfaces"
+)
+
+// Default values for the AwsEcsAPI struct.
+const (
+	DefaultMaxAttempts = 5
+	DefaultBaseDelay   = 100 * time.Millisecond
+	DefaultMaxDelay    = 5 * time.Second
+	DefaultBudget      = 10
+	DefaultBudgetRatio = 0.1
+)
+
+// AwsEcsAPI decorates an AwsEcsAPI by retrying calls failing with throttling
+// or transient errors. Between attempts it waits a random duration of up to
+// the base delay doubled for every attempt, capped at the max delay ("full
+// jitter").
+//
+// Retries are limited by a budget shared by all calls: every retry takes a
+// token and every successful call returns a fraction of a token. Calls are
+// not retried while less than half of the budget is left, so that a
+// persistent outage does not multiply the load on the ECS API.
+type AwsEcsAPI struct {
+	api interfaces.AwsEcsAPI
+
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+	budget      float64
+	budgetRatio float64
+
+	mutex  sync.Mutex
+	tokens float64
+
+	// sleep and random are replaced in tests.
+	sleep  func(context.Context, time.Duration) error
+	random func(int64) int64
+}
+
+// AwsEcsAPIOption defines the type used to further configure an AwsEcsAPI.
+type AwsEcsAPIOption func(*AwsEcsAPI) error
+
+// NewAwsEcsAPI creates a new retrying AwsEcsAPI decorator.
+func NewAwsEcsAPI(api interfaces.AwsEcsAPI, options ...AwsEcsAPIOption) (*AwsEcsAPI, error) {
+	r := &AwsEcsAPI{
+		api:         api,
+		maxAttempts: DefaultMaxAttempts,
+		baseDelay:   DefaultBaseDelay,
+		maxDelay:    DefaultMaxDelay,
+		budget:      DefaultBudget,
+		budgetRatio: DefaultBudgetRatio,
+		sleep:       sleep,
+		random:      rand.Int63n,
+	}
+
+	for _, opt := range options {
+		err := opt(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	r.tokens = r.budget
+
+	return r, nil
+}
+
+// WithMaxAttempts configures the maximum number of attempts of a call,
+// including the first one.
+func WithMaxAttempts(attempts int) AwsEcsAPIOption {
+	return func(r *AwsEcsAPI) error {
+		if attempts < 1 {
+			return fmt.Errorf("invalid max attempts: %d", attempts)
+		}
+
+		r.maxAttempts = attempts
+		return nil
+	}
+}
+
+// WithBackoff configures the base delay, which is doubled for every attempt,
+// and the maximum delay between attempts.
+func WithBackoff(baseDelay, maxDelay time.Duration) AwsEcsAPIOption {
+	return func(r *AwsEcsAPI) error {
+		if baseDelay <= 0 || maxDelay < baseDelay {
+			return fmt.Errorf("invalid backoff: %s-%s", baseDelay, maxDelay)
+		}
+
+		r.baseDelay = baseDelay
+		r.maxDelay = maxDelay
+		return nil
+	}
+}
+
+// WithBudget configures the retry budget: the maximum number of tokens, and
+// the fraction of a token returned by every successful call.
+func WithBudget(tokens, ratio float64) AwsEcsAPIOption {
+	return func(r *AwsEcsAPI) error {
+		if tokens < 1 || ratio < 0 {
+			return fmt.Errorf("invalid retry budget: %g tokens, ratio %g", tokens, ratio)
+		}
+
+		r.budget = tokens
+		r.budgetRatio = ratio
+		return nil
+	}
+}
+
+// ListServices implements the AwsEcsAPI interface.
+func (r *AwsEcsAPI) ListServices() ([]string, error) {
+	return r.ListServicesWithContext(context.Background())
+}
+
+// ListServicesWithContext implements the AwsEcsAPI interface. Every page is
+// retried separately.
+func (r *AwsEcsAPI) ListServicesWithContext(ctx context.Context) ([]string, error) {
+	return interfaces.ListAllServices(ctx, r)
+}
+
+// ListServicesPageWithContext implements the AwsEcsAPI interface.
+func (r *AwsEcsAPI) ListServicesPageWithContext(ctx context.Context, token string) ([]string, string, error) {
+	var names []string
+	var next string
+
+	err := r.do(ctx, func() error {
+		var err error
+		names, next, err = r.api.ListServicesPageWithContext(ctx, token)
+		return err
+	})
+
+	return names, next, err
+}
+
+// DescribeService implements the AwsEcsAPI interface.
+func (r *AwsEcsAPI) DescribeService(serviceArn string) (*ecs.Service, error) {
+	return r.DescribeServiceWithContext(context.Background(), serviceArn)
+}
+
+// DescribeServiceWithContext implements the AwsEcsAPI interface.
+func (r *AwsEcsAPI) DescribeServiceWithContext(ctx context.Context, serviceArn string) (*ecs.Service, error) {
+	var service *ecs.Service
+
+	err := r.do(ctx, func() error {
+		var err error
+		service, err = r.api.DescribeServiceWithContext(ctx, serviceArn)
+		return err
+	})
+
+	return service, err
+}
+
+// DescribeTaskDefinition implements the AwsEcsAPI interface.
+func (r *AwsEcsAPI) DescribeTaskDefinition(taskDefArn string) (*ecs.TaskDefinition, error) {
+	return r.DescribeTaskDefinitionWithContext(context.Background(), taskDefArn)
+}
+
+// DescribeTaskDefinitionWithContext implements the AwsEcsAPI interface.
+func (r *AwsEcsAPI) DescribeTaskDefinitionWithContext(ctx context.Context, taskDefArn string) (*ecs.TaskDefinition, error) {
+	var tdef *ecs.TaskDefinition
+
+	err := r.do(ctx, func() error {
+		var err error
+		tdef, err = r.api.DescribeTaskDefinitionWithContext(ctx, taskDefArn)
+		return err
+	})
+
+	return tdef, err
+}
+
+// do calls the function until it succeeds, fails with an error that should
+// not be retried, the max attempts are reached, the budget is exhausted or
+// the context is done. If the context is done while waiting for the next
+// attempt, the error of the last attempt is returned.
+func (r *AwsEcsAPI) do(ctx context.Context, call func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := call()
+		if err == nil {
+			r.succeeded()
+			return nil
+		}
+
+		if !IsRetryable(err) || attempt >= r.maxAttempts || !r.takeToken() {
+			return err
+		}
+
+		if r.sleep(ctx, r.delay(attempt)) != nil {
+			return err
+		}
+	}
+}
+
+// sleep waits for the duration, or until the context is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// delay returns a random delay before the next attempt.
+func (r *AwsEcsAPI) delay(attempt int) time.Duration {
+	max := r.maxDelay
+
+	if attempt < 32 {
+		if d := r.baseDelay << uint(attempt-1); d > 0 && d < max {
+			max = d
+		}
+	}
+
+	return time.Duration(r.random(int64(max) + 1))
+}
+
+func (r *AwsEcsAPI) succeeded() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.tokens += r.budgetRatio
+	if r.tokens > r.budget {
+		r.tokens = r.budget
+	}
+}
+
+// takeToken takes a token from the budget if more than half of it is left.
+func (r *AwsEcsAPI) takeToken() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.tokens <= r.budget/2 {
+		return false
+	}
+
+	r.tokens--
+
+	return true
+}
+
+// IsRetryable returns whether the error is an AWS error caused by throttling,
+// a transient network problem or an internal error of the ECS API. Other
+// errors, such as the not found errors of the AwsEcsAPI interface, are never
+// retried.
+func IsRetryable(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+
+	// ServerException is the code of ECS internal errors
+	return request.IsErrorThrottle(awsErr) ||
+		request.IsErrorRetryable(awsErr) ||
+		awsErr.Code() == "ServerException"
+}
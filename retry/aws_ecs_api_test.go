@@ -0,0 +1,167 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/off-sync/platform-proxy-aws/interfaces"
+)
+
+func setUp(t *testing.T, options ...AwsEcsAPIOption) (*AwsEcsAPI, *interfaces.AwsEcsAPIMock, *[]time.Duration) {
+	api := interfaces.NewAwsEcsAPIMock()
+	api.ServiceNames = []string{"web"}
+	api.Services["web"] = &ecs.Service{}
+	api.TaskDefs["webTaskDef"] = &ecs.TaskDefinition{}
+
+	r, err := NewAwsEcsAPI(api, options...)
+	assert.Nil(t, err)
+
+	var delays []time.Duration
+
+	r.sleep = func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return ctx.Err()
+	}
+	r.random = func(n int64) int64 { return n - 1 }
+
+	return r, api, &delays
+}
+
+func TestRetryShouldSucceedAfterThrottling(t *testing.T) {
+	r, api, delays := setUp(t)
+	api.ListServicesFailures = 3
+
+	names, err := r.ListServices()
+	assert.Nil(t, err)
+	assert.EqualValues(t, []string{"web"}, names)
+	assert.Equal(t, 4, api.ListServicesCalls)
+
+	// the random delay is at most the base delay doubled for every attempt
+	assert.EqualValues(t, []time.Duration{
+		DefaultBaseDelay,
+		2 * DefaultBaseDelay,
+		4 * DefaultBaseDelay,
+	}, *delays)
+}
+
+func TestRetryShouldCapDelay(t *testing.T) {
+	r, api, delays := setUp(t, WithBackoff(time.Second, 3*time.Second), WithMaxAttempts(4))
+	api.DescribeServiceFailures = 3
+
+	_, err := r.DescribeService("web")
+	assert.Nil(t, err)
+
+	assert.EqualValues(t, []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}, *delays)
+}
+
+func TestRetryShouldGiveUpAfterMaxAttempts(t *testing.T) {
+	r, api, _ := setUp(t, WithMaxAttempts(3))
+	api.DescribeTaskDefinitionFailures = 3
+
+	_, err := r.DescribeTaskDefinition("webTaskDef")
+	assert.NotNil(t, err)
+	assert.Equal(t, 3, api.DescribeTaskDefinitionCalls)
+}
+
+func TestRetryShouldNotRetryPermanentErrors(t *testing.T) {
+	r, api, _ := setUp(t)
+
+	_, err := r.DescribeService("unknown")
+	assert.Equal(t, interfaces.ErrServiceNotFound, err)
+	assert.Equal(t, 1, api.DescribeServiceCalls)
+
+	api.DescribeServiceFailures = 1
+	api.TransientError = awserr.New("ClusterNotFoundException", "Cluster not found", nil)
+
+	_, err = r.DescribeService("web")
+	assert.NotNil(t, err)
+	assert.Equal(t, 2, api.DescribeServiceCalls)
+}
+
+func TestRetryShouldRespectBudget(t *testing.T) {
+	r, api, _ := setUp(t, WithBudget(4, 1), WithMaxAttempts(10))
+
+	// half of the budget can be used for retries
+	api.ListServicesFailures = 10
+
+	_, err := r.ListServices()
+	assert.NotNil(t, err)
+	assert.Equal(t, 3, api.ListServicesCalls)
+
+	// successful calls replenish the budget
+	api.ListServicesFailures = 0
+	_, err = r.ListServices()
+	assert.Nil(t, err)
+
+	api.ListServicesFailures = 10
+	api.ListServicesCalls = 0
+
+	_, err = r.ListServices()
+	assert.NotNil(t, err)
+	assert.Equal(t, 2, api.ListServicesCalls)
+}
+
+func TestRetryShouldStopWhenContextIsDone(t *testing.T) {
+	r, api, delays := setUp(t)
+	api.ListServicesFailures = 3
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// the call fails before the mock is called
+	_, err := r.ListServicesWithContext(ctx)
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 1, api.ListServicesCalls)
+	assert.Len(t, *delays, 0)
+}
+
+func TestRetryShouldNotRetryAfterCancellationWhileWaiting(t *testing.T) {
+	r, api, _ := setUp(t)
+	api.ListServicesFailures = 3
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	r.sleep = func(ctx context.Context, d time.Duration) error {
+		cancel()
+		return ctx.Err()
+	}
+
+	// the error of the last attempt is returned
+	_, err := r.ListServicesWithContext(ctx)
+	assert.True(t, IsRetryable(err))
+	assert.Equal(t, 1, api.ListServicesCalls)
+}
+
+func TestSleep(t *testing.T) {
+	assert.Nil(t, sleep(context.Background(), time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.Equal(t, context.Canceled, sleep(ctx, time.Hour))
+}
+
+func TestIsRetryable(t *testing.T) {
+	assert.True(t, IsRetryable(awserr.New("ThrottlingException", "Rate exceeded", nil)))
+	assert.True(t, IsRetryable(awserr.New("ServerException", "Internal error", nil)))
+	assert.False(t, IsRetryable(awserr.New("AccessDeniedException", "Access denied", nil)))
+	assert.False(t, IsRetryable(interfaces.ErrServiceNotFound))
+	assert.False(t, IsRetryable(errors.New("failed")))
+}
+
+func TestInvalidOptions(t *testing.T) {
+	_, err := NewAwsEcsAPI(nil, WithMaxAttempts(0))
+	assert.NotNil(t, err)
+
+	_, err = NewAwsEcsAPI(nil, WithBackoff(time.Second, time.Millisecond))
+	assert.NotNil(t, err)
+
+	_, err = NewAwsEcsAPI(nil, WithBudget(0, 0.1))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,180 @@
+// Copyright (c) 2017 off-sync
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package routing
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// ChangeType is the type of a change to a routing table.
+type ChangeType string
+
+// Change types.
+const (
+	RouteAdded     ChangeType = "route-added"
+	RouteRemoved   ChangeType = "route-removed"
+	RouteChanged   ChangeType = "route-changed"
+	BackendAdded   ChangeType = "backend-added"
+	BackendRemoved ChangeType = "backend-removed"
+	BackendChanged ChangeType = "backend-changed"
+)
+
+// Change is a single difference between two routing tables.
+type Change struct {
+	Type    ChangeType `json:"type"`
+	Service string     `json:"service"`
+
+	// Backend is the URL of the changed backend, if any.
+	Backend string `json:"backend,omitempty"`
+
+	// Detail describes the change.
+	Detail string `json:"detail,omitempty"`
+}
+
+func (c *Change) String() string {
+	s := fmt.Sprintf("%s %s", c.Type, c.Service)
+
+	if c.Backend != "" {
+		s += " " + c.Backend
+	}
+
+	if c.Detail != "" {
+		s += ": " + c.Detail
+	}
+
+	return s
+}
+
+// Diff returns the changes needed to go from the old routing table to this
+// routing table.
+func (t *Table) Diff(old *Table) []*Change {
+	var changes []*Change
+
+	for _, oldRoute := range old.Routes {
+		if t.Route(oldRoute.Service) == nil {
+			changes = append(changes, &Change{Type: RouteRemoved, Service: oldRoute.Service})
+		}
+	}
+
+	for _, route := range t.Routes {
+		oldRoute := old.Route(route.Service)
+		if oldRoute == nil {
+			changes = append(changes, &Change{Type: RouteAdded, Service: route.Service})
+			continue
+		}
+
+		if !reflect.DeepEqual(route.Hosts, oldRoute.Hosts) {
+			changes = append(changes, &Change{
+				Type:    RouteChanged,
+				Service: route.Service,
+				Detail:  fmt.Sprintf("hosts %v -> %v", oldRoute.Hosts, route.Hosts),
+			})
+		}
+
+		if route.Error != oldRoute.Error {
+			changes = append(changes, &Change{
+				Type:    RouteChanged,
+				Service: route.Service,
+				Detail:  fmt.Sprintf("error %q -> %q", oldRoute.Error, route.Error),
+			})
+		}
+
+		changes = append(changes, diffBackends(route, oldRoute)...)
+	}
+
+	return changes
+}
+
+// diffBackends compares the backends of the routes as multisets, as a route
+// may have several backends with the same URL, e.g. when deployments of a
+// service share a host name. Identical backends are matched first; remaining
+// backends with the same URL are reported as changed.
+func diffBackends(route, oldRoute *Route) []*Change {
+	var changes []*Change
+
+	unmatched := append([]*Backend{}, oldRoute.Backends...)
+
+	var added []*Backend
+
+	for _, backend := range route.Backends {
+		i := indexBackend(unmatched, func(old *Backend) bool {
+			return reflect.DeepEqual(backend, old)
+		})
+
+		if i < 0 {
+			added = append(added, backend)
+			continue
+		}
+
+		unmatched = append(unmatched[:i], unmatched[i+1:]...)
+	}
+
+	var changed []*Change
+
+	for _, backend := range added {
+		i := indexBackend(unmatched, func(old *Backend) bool {
+			return backend.URL == old.URL
+		})
+
+		if i < 0 {
+			changed = append(changed, &Change{
+				Type:    BackendAdded,
+				Service: route.Service,
+				Backend: backend.URL,
+			})
+
+			continue
+		}
+
+		oldBackend := unmatched[i]
+		unmatched = append(unmatched[:i], unmatched[i+1:]...)
+
+		changed = append(changed, &Change{
+			Type:    BackendChanged,
+			Service: route.Service,
+			Backend: backend.URL,
+			Detail:  fmt.Sprintf("%+v -> %+v", *oldBackend, *backend),
+		})
+	}
+
+	for _, oldBackend := range unmatched {
+		changes = append(changes, &Change{
+			Type:    BackendRemoved,
+			Service: route.Service,
+			Backend: oldBackend.URL,
+		})
+	}
+
+	return append(changes, changed...)
+}
+
+// indexBackend returns the index of the first backend matching the predicate,
+// or -1 if none matches.
+func indexBackend(backends []*Backend, match func(*Backend) bool) int {
+	for i, backend := range backends {
+		if match(backend) {
+			return i
+		}
+	}
+
+	return -1
+}
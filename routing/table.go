@@ -0,0 +1,155 @@
+// Copyright (c) 2017 off-sync
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package routing builds the routing table of the proxy from the services
+// discovered by the service repository.
+package routing
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+
+	"github.com/off-sync/platform-proxy-aws/services"
+)
+
+// ServiceRepository describes the services to build a routing table for.
+// It is implemented by services.ServiceRepository.
+type ServiceRepository interface {
+	ListServices() ([]string, error)
+	DescribeServiceDetails(name string) (*services.ServiceDescription, error)
+}
+
+// Backend is a server that receives traffic of a route.
+type Backend struct {
+	URL      string            `json:"url"`
+	Weight   int               `json:"weight"`
+	Protocol services.Protocol `json:"protocol"`
+
+	// TLS settings, only used for https backends.
+	TLSServerName string `json:"tlsServerName,omitempty"`
+	TLSSkipVerify bool   `json:"tlsSkipVerify,omitempty"`
+
+	// Service is the ECS service providing the backend.
+	Service string `json:"service"`
+
+	TaskDefinition string `json:"taskDefinition"`
+}
+
+// Route routes the traffic of a service to its backends.
+type Route struct {
+	Service  string     `json:"service"`
+	Hosts    []string   `json:"hosts,omitempty"`
+	Backends []*Backend `json:"backends"`
+
+	// Error is set if the service could not be described.
+	Error string `json:"error,omitempty"`
+}
+
+// Table is a routing table, ordered by service.
+type Table struct {
+	Routes []*Route `json:"routes"`
+}
+
+// BuildTable builds the routing table for all services of the repository.
+// Services that cannot be described are included with their error, so that
+// a single misconfigured service does not hide the others.
+func BuildTable(r ServiceRepository) (*Table, error) {
+	names, err := r.ListServices()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(names)
+
+	table := &Table{Routes: make([]*Route, 0, len(names))}
+
+	for _, name := range names {
+		route := &Route{
+			Service:  name,
+			Backends: []*Backend{},
+		}
+
+		desc, err := r.DescribeServiceDetails(name)
+		if err != nil {
+			route.Error = err.Error()
+		} else {
+			route.Hosts = desc.Hosts
+			route.Backends = backends(desc)
+		}
+
+		table.Routes = append(table.Routes, route)
+	}
+
+	return table, nil
+}
+
+func backends(desc *services.ServiceDescription) []*Backend {
+	var backends []*Backend
+
+	for _, set := range desc.ServerSets {
+		for _, server := range set.Servers {
+			backends = append(backends, &Backend{
+				URL:            server.URL.String(),
+				Weight:         set.Weight,
+				Protocol:       server.Transport.Protocol,
+				TLSServerName:  server.Transport.TLSServerName,
+				TLSSkipVerify:  server.Transport.TLSSkipVerify,
+				Service:        set.Service,
+				TaskDefinition: server.TaskDefinition,
+			})
+		}
+	}
+
+	return backends
+}
+
+// Route returns the route of the service, or nil if the table has no route
+// for the service.
+func (t *Table) Route(service string) *Route {
+	for _, route := range t.Routes {
+		if route.Service == service {
+			return route
+		}
+	}
+
+	return nil
+}
+
+// ReadTable reads a routing table in JSON format, e.g. a snapshot written by
+// Write.
+func ReadTable(r io.Reader) (*Table, error) {
+	var table Table
+
+	err := json.NewDecoder(r).Decode(&table)
+	if err != nil {
+		return nil, err
+	}
+
+	return &table, nil
+}
+
+// Write writes the routing table in JSON format.
+func (t *Table) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(t)
+}
@@ -0,0 +1,163 @@
+package routing
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/off-sync/platform-proxy-aws/interfaces"
+	"github.com/off-sync/platform-proxy-aws/services"
+)
+
+func addService(api *interfaces.AwsEcsAPIMock, name, hostname string, labels map[string]string) {
+	api.ServiceNames = append(api.ServiceNames, name)
+
+	api.Services[name] = &ecs.Service{
+		ServiceName:    aws.String(name),
+		TaskDefinition: aws.String(name + "TaskDef"),
+	}
+
+	api.TaskDefs[name+"TaskDef"] = &ecs.TaskDefinition{
+		ContainerDefinitions: []*ecs.ContainerDefinition{
+			&ecs.ContainerDefinition{
+				DockerLabels: aws.StringMap(labels),
+				Name:         aws.String(services.DefaultServerContainerName),
+				Hostname:     aws.String(hostname),
+			},
+		},
+	}
+}
+
+func setUp(t *testing.T) (*services.ServiceRepository, *interfaces.AwsEcsAPIMock) {
+	api := interfaces.NewAwsEcsAPIMock()
+
+	r, err := services.NewServiceRepository(api, services.WithCanaryGrouping(true))
+	assert.Nil(t, err)
+
+	addService(api, "web", "web", nil)
+	addService(api, "web-canary", "canary", map[string]string{
+		services.DefaultDockerLabelCanaryOf: "web",
+		services.DefaultDockerLabelWeight:   "10",
+	})
+	addService(api, "api", "api", map[string]string{
+		services.DefaultDockerLabelPort: "abc",
+	})
+
+	return r, api
+}
+
+func TestBuildTable(t *testing.T) {
+	r, _ := setUp(t)
+
+	table, err := BuildTable(r)
+	assert.Nil(t, err)
+
+	assert.EqualValues(t, &Table{Routes: []*Route{
+		&Route{
+			Service:  "api",
+			Backends: []*Backend{},
+			Error:    "invalid port: abc",
+		},
+		&Route{
+			Service: "web",
+			Backends: []*Backend{
+				&Backend{
+					URL:            "http://web:8080",
+					Weight:         90,
+					Protocol:       services.ProtocolHTTP1,
+					Service:        "web",
+					TaskDefinition: "webTaskDef",
+				},
+				&Backend{
+					URL:            "http://canary:8080",
+					Weight:         10,
+					Protocol:       services.ProtocolHTTP1,
+					Service:        "web-canary",
+					TaskDefinition: "web-canaryTaskDef",
+				},
+			},
+		},
+	}}, table)
+}
+
+func TestBuildTableShouldReturnErrorWhenAPIFails(t *testing.T) {
+	r, api := setUp(t)
+	api.FailListServices = true
+
+	_, err := BuildTable(r)
+	assert.NotNil(t, err)
+}
+
+func TestTableSnapshot(t *testing.T) {
+	r, _ := setUp(t)
+
+	table, err := BuildTable(r)
+	assert.Nil(t, err)
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, table.Write(buf))
+
+	read, err := ReadTable(buf)
+	assert.Nil(t, err)
+	assert.EqualValues(t, table, read)
+	assert.Len(t, table.Diff(read), 0)
+}
+
+func TestTableDiff(t *testing.T) {
+	r, api := setUp(t)
+
+	old, err := BuildTable(r)
+	assert.Nil(t, err)
+
+	addService(api, "admin", "admin", nil)
+	api.TaskDefs["apiTaskDef"].ContainerDefinitions[0].DockerLabels = nil
+	api.TaskDefs["web-canaryTaskDef"].ContainerDefinitions[0].Hostname = aws.String("canary2")
+	api.TaskDefs["web-canaryTaskDef"].ContainerDefinitions[0].DockerLabels[services.DefaultDockerLabelWeight] = aws.String("20")
+
+	table, err := BuildTable(r)
+	assert.Nil(t, err)
+
+	var changes []string
+	for _, change := range table.Diff(old) {
+		changes = append(changes, string(change.Type)+" "+change.Service+" "+change.Backend)
+	}
+
+	assert.EqualValues(t, []string{
+		"route-added admin ",
+		"route-changed api ",
+		"backend-added api http://api:8080",
+		"backend-removed web http://canary:8080",
+		"backend-changed web http://web:8080",
+		"backend-added web http://canary2:8080",
+	}, changes)
+
+	old.Routes = old.Routes[1:]
+	table.Routes = table.Routes[:1]
+
+	assert.Equal(t, RouteRemoved, table.Diff(old)[0].Type)
+}
+
+func TestTableDiffShouldCompareDuplicateBackends(t *testing.T) {
+	backend := func(taskDef string) *Backend {
+		return &Backend{URL: "http://web:8080", Weight: 100, Service: "web", TaskDefinition: taskDef}
+	}
+
+	old := &Table{Routes: []*Route{{Service: "web", Backends: []*Backend{backend("web:1"), backend("web:2")}}}}
+	table := &Table{Routes: []*Route{{Service: "web", Backends: []*Backend{backend("web:2")}}}}
+
+	changes := table.Diff(old)
+	if assert.Len(t, changes, 1) {
+		assert.Equal(t, BackendRemoved, changes[0].Type)
+		assert.Equal(t, "http://web:8080", changes[0].Backend)
+	}
+
+	changes = old.Diff(table)
+	if assert.Len(t, changes, 1) {
+		assert.Equal(t, BackendAdded, changes[0].Type)
+	}
+
+	assert.Len(t, table.Diff(table), 0)
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2017 off-sync
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package services
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/off-sync/platform-proxy-aws/interfaces"
+)
+
+// All calls to the AWS ECS API go through the methods in this file, which
+// trace the calls and mark their errors as API errors. API errors are
+// unmarked before they are returned by the exported methods.
+
+// apiError is an error returned by a failed AWS ECS API call.
+type apiError struct {
+	err error
+}
+
+func (e *apiError) Error() string {
+	return e.err.Error()
+}
+
+// markAPIError marks the error of an AWS ECS API call as an API error, unless
+// it reports that the service or task definition was not found.
+func markAPIError(err error) error {
+	if err == nil || err == interfaces.ErrServiceNotFound || err == interfaces.ErrTaskDefinitionNotFound {
+		return err
+	}
+
+	return &apiError{err: err}
+}
+
+// isAPIError returns whether the error is a marked API error.
+func isAPIError(err error) bool {
+	_, ok := err.(*apiError)
+	return ok
+}
+
+// unmarkAPIError returns the original error of a marked API error.
+func unmarkAPIError(err error) error {
+	if apiErr, ok := err.(*apiError); ok {
+		return apiErr.err
+	}
+
+	return err
+}
+
+// listECSServices lists the ECS services in a span.
+func (r *ServiceRepository) listECSServices(ctx context.Context) ([]string, error) {
+	ctx, span := r.tracer.Start(ctx, "AwsEcsAPI.ListServices",
+		trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	names, err := r.api.ListServicesWithContext(ctx)
+	endSpan(span, err)
+
+	return names, markAPIError(err)
+}
+
+// describeECSService describes an ECS service in a span.
+func (r *ServiceRepository) describeECSService(ctx context.Context, serviceArn string) (*ecs.Service, error) {
+	ctx, span := r.tracer.Start(ctx, "AwsEcsAPI.DescribeService",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(AttributeServiceARN.String(serviceArn)))
+	defer span.End()
+
+	service, err := r.api.DescribeServiceWithContext(ctx, serviceArn)
+	endSpan(span, err)
+
+	if err == nil {
+		span.SetAttributes(AttributeTaskDefinition.String(aws.StringValue(service.TaskDefinition)))
+	}
+
+	return service, markAPIError(err)
+}
+
+// describeECSTaskDefinition describes an ECS task definition in a span.
+func (r *ServiceRepository) describeECSTaskDefinition(ctx context.Context, taskDefArn string) (*ecs.TaskDefinition, error) {
+	ctx, span := r.tracer.Start(ctx, "AwsEcsAPI.DescribeTaskDefinition",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(AttributeTaskDefinition.String(taskDefArn)))
+	defer span.End()
+
+	tdef, err := r.api.DescribeTaskDefinitionWithContext(ctx, taskDefArn)
+	endSpan(span, err)
+
+	return tdef, markAPIError(err)
+}
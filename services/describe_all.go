@@ -0,0 +1,172 @@
+// Copyright (c) 2017 off-sync
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// DefaultWorkers is the default number of services described concurrently.
+const DefaultWorkers = 8
+
+// WithWorkers configures a service repository with the number of services
+// described concurrently by DescribeAllServices and Refresh.
+func WithWorkers(workers int) ServiceRepositoryOption {
+	return func(r *ServiceRepository) error {
+		if workers < 1 {
+			return fmt.Errorf("invalid number of workers: %d", workers)
+		}
+
+		r.workers = workers
+		return nil
+	}
+}
+
+// DescribeErrors are the errors describing services, by service name.
+type DescribeErrors map[string]error
+
+// Error implements the error interface.
+func (e DescribeErrors) Error() string {
+	names := make([]string, 0, len(e))
+	for name := range e {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	msgs := make([]string, 0, len(names))
+	for _, name := range names {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", name, e[name]))
+	}
+
+	return fmt.Sprintf("describing %d services failed: %s", len(e), strings.Join(msgs, "; "))
+}
+
+// DescribeAllServices lists all services and describes them concurrently,
+// using the configured number of workers. The descriptions of the services
+// that were described successfully are returned, ordered by name.
+//
+// A service that cannot be described does not fail the others: its error is
+// returned as part of DescribeErrors. If the context is cancelled, no more
+// services are described and the context error is returned together with the
+// descriptions so far.
+func (r *ServiceRepository) DescribeAllServices(ctx context.Context) ([]*ServiceDescription, error) {
+	ctx, span := r.tracer.Start(ctx, "ServiceRepository.DescribeAllServices")
+	defer span.End()
+
+	names, err := r.ListServicesWithContext(ctx)
+	if err != nil {
+		endSpan(span, err)
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int("platform_proxy.services", len(names)))
+
+	descs, err := r.describeConcurrently(ctx, names, r.describeServiceDetailsOrStale)
+	endSpan(span, err)
+
+	return descs, err
+}
+
+// describeConcurrently describes the services using the provided function and
+// the configured number of workers.
+func (r *ServiceRepository) describeConcurrently(ctx context.Context, names []string,
+	describe func(context.Context, string) (*ServiceDescription, error)) ([]*ServiceDescription, error) {
+
+	var mutex sync.Mutex
+
+	var descs []*ServiceDescription
+	errs := DescribeErrors{}
+
+	r.forEachConcurrently(ctx, names, func(ctx context.Context, name string) {
+		desc, err := describe(ctx, name)
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if err != nil {
+			errs[name] = unmarkAPIError(err)
+			return
+		}
+
+		descs = append(descs, desc)
+	})
+
+	sort.Slice(descs, func(i, j int) bool {
+		return descs[i].Name < descs[j].Name
+	})
+
+	if err := ctx.Err(); err != nil {
+		return descs, err
+	}
+
+	if len(errs) > 0 {
+		return descs, errs
+	}
+
+	return descs, nil
+}
+
+// forEachConcurrently calls the function for every name using the configured
+// number of workers, and returns when all calls have returned. Once the
+// context is cancelled, the function is not called anymore.
+func (r *ServiceRepository) forEachConcurrently(ctx context.Context, names []string, f func(context.Context, string)) {
+	queue := make(chan string)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < r.workers && i < len(names); i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for name := range queue {
+				if ctx.Err() != nil {
+					// no more names are handled once cancelled
+					continue
+				}
+
+				f(ctx, name)
+			}
+		}()
+	}
+
+	for _, name := range names {
+		if ctx.Err() != nil {
+			break
+		}
+
+		select {
+		case queue <- name:
+		case <-ctx.Done():
+		}
+	}
+
+	close(queue)
+	wg.Wait()
+}
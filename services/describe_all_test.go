@@ -0,0 +1,138 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithWorkersShouldRejectInvalidNumber(t *testing.T) {
+	_, err := NewServiceRepository(nil, WithWorkers(0))
+	assert.NotNil(t, err)
+}
+
+func TestDescribeAllServices(t *testing.T) {
+	r, api := setUp(t, WithWorkers(3))
+
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("service%02d", i)
+		addService(api, name, name, nil)
+	}
+
+	descs, err := r.DescribeAllServices(context.Background())
+	assert.Nil(t, err)
+
+	if assert.Len(t, descs, 10) {
+		for i, desc := range descs {
+			assert.Equal(t, fmt.Sprintf("service%02d", i), desc.Name)
+		}
+	}
+
+	assert.Len(t, r.Snapshot().Services, 10)
+}
+
+func TestDescribeAllServicesShouldAggregateErrors(t *testing.T) {
+	r, api := setUp(t)
+
+	addService(api, "web", "web", nil)
+	addService(api, "api", "api", map[string]string{
+		DefaultDockerLabelPort: "abc",
+	})
+	addService(api, "admin", "admin", map[string]string{
+		DefaultDockerLabelPort: "def",
+	})
+
+	descs, err := r.DescribeAllServices(context.Background())
+
+	if assert.Len(t, descs, 1) {
+		assert.Equal(t, "web", descs[0].Name)
+	}
+
+	if errs, ok := err.(DescribeErrors); assert.True(t, ok) {
+		assert.Len(t, errs, 2)
+		assert.Equal(t, "invalid port: abc", errs["api"].Error())
+		assert.Equal(t, "describing 2 services failed: admin: invalid port: def; api: invalid port: abc", errs.Error())
+	}
+}
+
+func TestDescribeAllServicesShouldFailWhenListingFails(t *testing.T) {
+	r, api := setUp(t)
+	api.FailListServices = true
+
+	descs, err := r.DescribeAllServices(context.Background())
+	assert.Nil(t, descs)
+	assert.NotNil(t, err)
+}
+
+func TestDescribeAllServicesShouldHonourCancellation(t *testing.T) {
+	r, api := setUp(t)
+
+	addService(api, "web", "web", nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := r.DescribeAllServices(ctx)
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestDescribeAllServicesShouldListCanariesOnce(t *testing.T) {
+	r, api := setUp(t, WithCanaryGrouping(true))
+
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("service%02d", i)
+		addService(api, name, name, nil)
+	}
+
+	addService(api, "service00-canary", "canary", map[string]string{
+		DefaultDockerLabelCanaryOf: "service00",
+		DefaultDockerLabelWeight:   "10",
+	})
+
+	descs, err := r.DescribeAllServices(context.Background())
+	assert.Nil(t, err)
+	assert.Len(t, descs, 10)
+	assert.Len(t, descs[0].ServerSets, 2)
+
+	// the canaries are taken from the index built by the listing
+	assert.Equal(t, 1, api.ListServicesCalls)
+	assert.Equal(t, 11+10, api.DescribeServiceCalls)
+}
+
+func TestDescribeConcurrentlyShouldStopWhenCancelledWhileRunning(t *testing.T) {
+	r, _ := setUp(t, WithWorkers(2))
+
+	names := make([]string, 100)
+	for i := range names {
+		names[i] = fmt.Sprintf("service%02d", i)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mutex sync.Mutex
+	calls := 0
+
+	descs, err := r.describeConcurrently(ctx, names, func(ctx context.Context, name string) (*ServiceDescription, error) {
+		mutex.Lock()
+		calls++
+		if calls == 5 {
+			cancel()
+		}
+		mutex.Unlock()
+
+		return &ServiceDescription{Name: name}, nil
+	})
+
+	assert.Equal(t, context.Canceled, err)
+	assert.True(t, len(descs) < len(names))
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	// at most one more service per worker is described after cancelling
+	assert.True(t, calls <= 5+2, "calls: %d", calls)
+}
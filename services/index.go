@@ -0,0 +1,180 @@
+// Copyright (c) 2017 off-sync
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package services
+
+import (
+	"context"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+
+	"github.com/off-sync/platform-proxy-aws/interfaces"
+)
+
+// indexedService is an ECS service together with the docker labels of its
+// server containers.
+type indexedService struct {
+	name    string
+	service *ecs.Service
+	labels  map[string]*string
+
+	// err is set if the labels could not be resolved for another reason
+	// than an API error, e.g. a task definition without server containers.
+	err error
+}
+
+// indexServices describes the ECS services and resolves their labels using
+// the configured number of workers. The index is in the order of the names
+// and leaves out services that no longer exist. If an API call fails, no
+// more services are described and its error is returned.
+func (r *ServiceRepository) indexServices(ctx context.Context, names []string) ([]*indexedService, error) {
+	indexCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	positions := make(map[string]int, len(names))
+	for i, name := range names {
+		positions[name] = i
+	}
+
+	index := make([]*indexedService, len(names))
+
+	var mutex sync.Mutex
+	var apiErr error
+
+	r.forEachConcurrently(indexCtx, names, func(ctx context.Context, name string) {
+		service, err := r.describeECSService(ctx, name)
+		if err == interfaces.ErrServiceNotFound {
+			return
+		}
+
+		var labels map[string]*string
+		if err == nil {
+			labels, err = r.getServiceLabels(ctx, service)
+		}
+
+		if isAPIError(err) {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			if apiErr == nil {
+				apiErr = err
+				cancel()
+			}
+
+			return
+		}
+
+		index[positions[name]] = &indexedService{
+			name:    name,
+			service: service,
+			labels:  labels,
+			err:     err,
+		}
+	})
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	indexed := index[:0]
+
+	for _, service := range index {
+		if service != nil {
+			indexed = append(indexed, service)
+		}
+	}
+
+	return indexed, nil
+}
+
+// recordIndex records the canary services of the index, to be used when
+// describing services until the services are listed again.
+func (r *ServiceRepository) recordIndex(index []*indexedService) {
+	var canaries []*indexedService
+
+	for _, service := range index {
+		if _, isCanary := service.labels[r.dockerLabelCanaryOf]; isCanary {
+			canaries = append(canaries, service)
+		}
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.canaries = canaries
+	r.canariesIndexed = true
+}
+
+// canaryServices returns the canary services of the cluster as indexed by the
+// last listing of the services. If the services were not listed with canary
+// grouping enabled yet, they are listed and indexed once, so that describing
+// many services does not inspect the whole cluster for each of them.
+func (r *ServiceRepository) canaryServices(ctx context.Context) ([]*indexedService, error) {
+	if canaries, found := r.indexedCanaries(); found {
+		return canaries, nil
+	}
+
+	// only one caller indexes the services, the others wait for its result
+	r.indexMutex.Lock()
+	defer r.indexMutex.Unlock()
+
+	if canaries, found := r.indexedCanaries(); found {
+		return canaries, nil
+	}
+
+	names, err := r.listECSServices(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := r.indexServices(ctx, names)
+	if err != nil {
+		return nil, err
+	}
+
+	r.recordIndex(index)
+
+	canaries, _ := r.indexedCanaries()
+
+	return canaries, nil
+}
+
+func (r *ServiceRepository) indexedCanaries() ([]*indexedService, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.canaries, r.canariesIndexed
+}
+
+// isCanaryOf returns whether the indexed service is a canary of the service
+// with the provided name.
+func (r *ServiceRepository) isCanaryOf(candidate *indexedService, name string, service *ecs.Service) bool {
+	if candidate.name == name {
+		return false
+	}
+
+	return isService(aws.StringValue(candidate.labels[r.dockerLabelCanaryOf]), name, service)
+}
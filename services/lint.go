@@ -0,0 +1,71 @@
+// Copyright (c) 2017 off-sync
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// ReadTaskDefinition reads a task definition in JSON format, either as output
+// by `aws ecs describe-task-definition` or as a bare task definition as used
+// by `aws ecs register-task-definition --cli-input-json`.
+func ReadTaskDefinition(r io.Reader) (*ecs.TaskDefinition, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var output ecs.DescribeTaskDefinitionOutput
+
+	err = json.Unmarshal(data, &output)
+	if err != nil {
+		return nil, err
+	}
+
+	if output.TaskDefinition != nil {
+		return output.TaskDefinition, nil
+	}
+
+	var tdef ecs.TaskDefinition
+
+	err = json.Unmarshal(data, &tdef)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tdef.ContainerDefinitions) < 1 {
+		return nil, errors.New("no container definitions found")
+	}
+
+	return &tdef, nil
+}
+
+// LintTaskDefinition returns all problems the service repository finds in the
+// server containers and labels of the task definition. It does not use the
+// AWS ECS API, so the repository may be created without one.
+func (r *ServiceRepository) LintTaskDefinition(tdef *ecs.TaskDefinition) []error {
+	return r.validateTaskDefinition(tdef)
+}
@@ -0,0 +1,50 @@
+package services
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/stretchr/testify/assert"
+)
+
+func readTaskDefinition(t *testing.T, name string) *ecs.TaskDefinition {
+	f, err := os.Open("testdata/" + name)
+	assert.Nil(t, err)
+	defer f.Close()
+
+	tdef, err := ReadTaskDefinition(f)
+	assert.Nil(t, err)
+
+	return tdef
+}
+
+func TestReadTaskDefinition(t *testing.T) {
+	tdef := readTaskDefinition(t, "describe-task-definition.json")
+	assert.Equal(t, "web", aws.StringValue(tdef.Family))
+	assert.Equal(t, int64(3), aws.Int64Value(tdef.Revision))
+	assert.Len(t, tdef.ContainerDefinitions, 2)
+	assert.Equal(t, "80", aws.StringValue(tdef.ContainerDefinitions[0].DockerLabels[DefaultDockerLabelPort]))
+
+	tdef = readTaskDefinition(t, "task-definition.json")
+	assert.Equal(t, "api", aws.StringValue(tdef.Family))
+	assert.Len(t, tdef.ContainerDefinitions, 1)
+}
+
+func TestReadTaskDefinitionShouldReturnErrorOnInvalidInput(t *testing.T) {
+	_, err := ReadTaskDefinition(strings.NewReader("{"))
+	assert.NotNil(t, err)
+
+	_, err = ReadTaskDefinition(strings.NewReader(`{"family": "empty"}`))
+	assert.NotNil(t, err)
+}
+
+func TestLintTaskDefinition(t *testing.T) {
+	r, err := NewServiceRepository(nil)
+	assert.Nil(t, err)
+
+	assert.Len(t, r.LintTaskDefinition(readTaskDefinition(t, "describe-task-definition.json")), 0)
+	assert.Len(t, r.LintTaskDefinition(readTaskDefinition(t, "task-definition.json")), 2)
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2017 off-sync
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package services
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/off-sync/platform-proxy-aws/atomicfile"
+)
+
+// ReadSnapshotFile reads a snapshot from the provided JSON file, as written by
+// WriteFile.
+func ReadSnapshotFile(file string) (*Snapshot, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &Snapshot{}
+
+	err = json.Unmarshal(data, snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	return snapshot, nil
+}
+
+// WriteFile writes the snapshot to the provided file as JSON. The file is
+// replaced atomically so that a snapshot is never read partially written.
+func (s *Snapshot) WriteFile(file string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.WriteFile(file, data, 0644)
+}
+
+// Restore restores the state of the repository from the provided snapshot, e.g.
+// one persisted by a previous run. Until the repository is refreshed
+// successfully, ListServices, DescribeService and DescribeServiceDetails serve
+// the restored services without calling the AWS ECS API, as long as they are
+// not older than the maximum staleness. Restored descriptions are marked as
+// stale and their servers are filtered by the server filters.
+//
+// Restoring does not count as a discovery: LastDiscovery remains unset until
+// services are listed successfully.
+func (r *ServiceRepository) Restore(snapshot *Snapshot) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.restoredDiscovery = snapshot.Discovered
+	r.lastNames = append([]string{}, snapshot.Names...)
+	r.states = make(map[string]*ServiceState, len(snapshot.Services))
+
+	for _, state := range snapshot.Services {
+		copied := *state
+		r.states[state.Name] = &copied
+	}
+
+	r.restored = true
+}
+
+// Restored returns whether the repository is serving a restored snapshot.
+func (r *ServiceRepository) Restored() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.restored
+}
+
+// restoredServices returns the restored services, if a snapshot is being
+// served and it is recent enough.
+func (r *ServiceRepository) restoredServices() ([]string, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !r.restored {
+		return nil, false
+	}
+
+	if _, ok := r.withinMaxStaleness(r.restoredDiscovery); !ok {
+		return nil, false
+	}
+
+	return append([]string{}, r.lastNames...), true
+}
+
+// restoredDescription returns the restored description of the service, marked
+// as stale and with filtered servers, if a snapshot is being served and it
+// has a recent enough description.
+func (r *ServiceRepository) restoredDescription(name string) *ServiceDescription {
+	r.mutex.Lock()
+
+	if !r.restored {
+		r.mutex.Unlock()
+		return nil
+	}
+
+	state, found := r.states[name]
+	if !found || state.Description == nil {
+		r.mutex.Unlock()
+		return nil
+	}
+
+	if _, ok := r.withinMaxStaleness(state.Refreshed); !ok {
+		r.mutex.Unlock()
+		return nil
+	}
+
+	desc := *state.Description
+	desc.Stale = true
+
+	r.mutex.Unlock()
+
+	// the restored servers were filtered by the server filters of a previous
+	// run, so the filters of this run are applied to copies of the server sets
+	sets := desc.ServerSets
+	desc.ServerSets = make([]*ServerSet, 0, len(sets))
+
+	for _, set := range sets {
+		copied := *set
+		copied.Servers = r.filterServers(set.Servers)
+		desc.ServerSets = append(desc.ServerSets, &copied)
+	}
+
+	return &desc
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotWriteFileAndRead(t *testing.T) {
+	r, api := setUp(t)
+
+	addService(api, "web", "web", nil)
+
+	assert.Nil(t, r.Refresh())
+
+	dir, err := ioutil.TempDir("", "snapshot")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "snapshot.json")
+
+	snapshot := r.Snapshot()
+	assert.Nil(t, snapshot.WriteFile(file))
+
+	read, err := ReadSnapshotFile(file)
+	assert.Nil(t, err)
+	assert.Equal(t, snapshot.Names, read.Names)
+	assert.True(t, snapshot.Discovered.Equal(read.Discovered))
+
+	if assert.Len(t, read.Services, 1) {
+		assert.Equal(t, "web", read.Services[0].Name)
+		assert.Equal(t, snapshot.Services[0].Description.URLs(), read.Services[0].Description.URLs())
+	}
+}
+
+func TestReadSnapshotFileShouldFailOnMissingFile(t *testing.T) {
+	_, err := ReadSnapshotFile("does-not-exist.json")
+	assert.NotNil(t, err)
+}
+
+func TestRestoreServesSnapshotUntilRefreshed(t *testing.T) {
+	live, api := setUp(t)
+
+	addService(api, "web", "web", nil)
+
+	assert.Nil(t, live.Refresh())
+
+	r, api := setUp(t, WithMaxStaleness(time.Hour))
+	r.Restore(live.Snapshot())
+	assert.True(t, r.Restored())
+
+	// restoring is not a discovery
+	assert.True(t, r.LastDiscovery().IsZero())
+	assert.False(t, r.Snapshot().Discovered.IsZero())
+
+	// the restored services are served without calling the API
+	api.FailListServices = true
+	api.FailDescribeService = true
+
+	names, err := r.ListServices()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"web"}, names)
+
+	desc, err := r.DescribeServiceDetails("web")
+	assert.Nil(t, err)
+	assert.True(t, desc.Stale)
+
+	_, err = r.DescribeServiceDetails("admin")
+	assert.NotNil(t, err)
+
+	// a failed refresh keeps serving the snapshot
+	assert.NotNil(t, r.Refresh())
+	assert.True(t, r.Restored())
+
+	// after a successful refresh, live data is served
+	api.FailListServices = false
+	api.FailDescribeService = false
+	addService(api, "admin", "admin", nil)
+
+	assert.Nil(t, r.Refresh())
+	assert.False(t, r.Restored())
+	assert.False(t, r.LastDiscovery().IsZero())
+
+	names, err = r.ListServices()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"admin"}, names)
+
+	desc, err = r.DescribeServiceDetails("admin")
+	assert.Nil(t, err)
+	assert.False(t, desc.Stale)
+}
+
+func TestRestoreShouldNotServeSnapshotOlderThanMaxStaleness(t *testing.T) {
+	live, api := setUp(t)
+
+	addService(api, "web", "web", nil)
+
+	assert.Nil(t, live.Refresh())
+
+	snapshot := live.Snapshot()
+	snapshot.Discovered = snapshot.Discovered.Add(-2 * time.Hour)
+	snapshot.Services[0].Refreshed = snapshot.Services[0].Refreshed.Add(-2 * time.Hour)
+
+	r, api := setUp(t, WithMaxStaleness(time.Hour))
+	r.Restore(snapshot)
+
+	api.FailListServices = true
+	api.FailDescribeService = true
+
+	_, err := r.ListServices()
+	assert.NotNil(t, err)
+
+	_, err = r.DescribeServiceDetails("web")
+	assert.NotNil(t, err)
+
+	// without a maximum staleness, restored data is not served at all
+	r, api = setUp(t)
+	r.Restore(live.Snapshot())
+
+	api.FailListServices = true
+
+	_, err = r.ListServices()
+	assert.NotNil(t, err)
+}
+
+func TestRestoreAppliesServerFilters(t *testing.T) {
+	live, api := setUp(t)
+	setUpDeployments(api, 1)
+	api.ServiceNames = []string{"service1"}
+
+	assert.Nil(t, live.Refresh())
+
+	r, _ := setUp(t, WithMaxStaleness(time.Hour), WithServerFilters(urlFilter("http://v1:8080")))
+	r.Restore(live.Snapshot())
+
+	desc, err := r.DescribeServiceDetails("service1")
+	assert.Nil(t, err)
+
+	if assert.Len(t, desc.Servers(), 1) {
+		assert.NotEqual(t, "http://v1:8080", desc.Servers()[0].URL.String())
+	}
+
+	// the restored state keeps all servers
+	assert.Len(t, r.Snapshot().Services[0].Description.Servers(), 2)
+}
@@ -0,0 +1,348 @@
+// Copyright (c) 2017 off-sync
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package services
+
+import (
+	"encoding/json"
+	"math"
+	"net/url"
+	"time"
+
+	"github.com/off-sync/platform-proxy-domain/services"
+)
+
+// Protocol is the protocol spoken by a server.
+type Protocol string
+
+// Protocols.
+const (
+	ProtocolHTTP1 Protocol = "http/1.1"
+	ProtocolH2C   Protocol = "h2c"
+	ProtocolGRPC  Protocol = "grpc"
+)
+
+// Transport describes how to connect to a server.
+type Transport struct {
+	Protocol Protocol `json:"protocol"`
+
+	// TLS settings, only used for https servers.
+	TLSServerName string `json:"tlsServerName,omitempty"`
+	TLSSkipVerify bool   `json:"tlsSkipVerify,omitempty"`
+}
+
+// HealthCheck describes how to actively check the health of a server.
+type HealthCheck struct {
+	// Path is the path requested by the health check.
+	Path string
+
+	Interval time.Duration
+	Timeout  time.Duration
+
+	// ExpectedStatus is the expected HTTP status code. Zero accepts any 2xx
+	// or 3xx status code.
+	ExpectedStatus int
+
+	// UnhealthyThreshold is the number of consecutive failed checks after
+	// which a server is considered unhealthy.
+	UnhealthyThreshold int
+}
+
+// healthCheckJSON is the JSON representation of a health check, using
+// durations such as "30s".
+type healthCheckJSON struct {
+	Path               string `json:"path"`
+	Interval           string `json:"interval"`
+	Timeout            string `json:"timeout"`
+	ExpectedStatus     int    `json:"expectedStatus,omitempty"`
+	UnhealthyThreshold int    `json:"unhealthyThreshold"`
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (h HealthCheck) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&healthCheckJSON{
+		Path:               h.Path,
+		Interval:           h.Interval.String(),
+		Timeout:            h.Timeout.String(),
+		ExpectedStatus:     h.ExpectedStatus,
+		UnhealthyThreshold: h.UnhealthyThreshold,
+	})
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (h *HealthCheck) UnmarshalJSON(data []byte) error {
+	var v healthCheckJSON
+
+	err := json.Unmarshal(data, &v)
+	if err != nil {
+		return err
+	}
+
+	interval, err := time.ParseDuration(v.Interval)
+	if err != nil {
+		return err
+	}
+
+	timeout, err := time.ParseDuration(v.Timeout)
+	if err != nil {
+		return err
+	}
+
+	*h = HealthCheck{
+		Path:               v.Path,
+		Interval:           interval,
+		Timeout:            timeout,
+		ExpectedStatus:     v.ExpectedStatus,
+		UnhealthyThreshold: v.UnhealthyThreshold,
+	}
+
+	return nil
+}
+
+// DefaultHealthCheck is used for servers without health check configuration.
+var DefaultHealthCheck = HealthCheck{
+	Path:               "/",
+	Interval:           30 * time.Second,
+	Timeout:            5 * time.Second,
+	UnhealthyThreshold: 3,
+}
+
+// Server describes a single server of a service together with the
+// deployment it belongs to.
+type Server struct {
+	URL       *url.URL  `json:"-"`
+	Transport Transport `json:"transport"`
+
+	// Container is the name of the server container.
+	Container string `json:"container"`
+
+	HealthCheck HealthCheck `json:"healthCheck"`
+
+	// Deployment information
+	DeploymentID     string `json:"deploymentId,omitempty"`
+	DeploymentStatus string `json:"deploymentStatus"`
+	TaskDefinition   string `json:"taskDefinition"`
+	Revision         int64  `json:"revision,omitempty"`
+}
+
+// serverJSON is the JSON representation of a server, which includes its URL
+// as a string.
+type serverJSON struct {
+	URL string `json:"url"`
+	*serverFields
+}
+
+// serverFields has the fields of a server without its JSON methods.
+type serverFields Server
+
+// MarshalJSON implements the json.Marshaler interface.
+func (s *Server) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&serverJSON{
+		URL:          s.URL.String(),
+		serverFields: (*serverFields)(s),
+	})
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (s *Server) UnmarshalJSON(data []byte) error {
+	v := &serverJSON{serverFields: (*serverFields)(s)}
+
+	err := json.Unmarshal(data, v)
+	if err != nil {
+		return err
+	}
+
+	s.URL, err = url.Parse(v.URL)
+
+	return err
+}
+
+// ServerSet is a weighted set of servers provided by a single ECS service.
+type ServerSet struct {
+	// Service is the name of the ECS service providing the servers.
+	Service string `json:"service"`
+
+	// Weight is the percentage of the traffic routed to this set.
+	Weight int `json:"weight"`
+
+	// Labels are the docker labels of the server containers of the primary
+	// deployment.
+	Labels map[string]string `json:"labels,omitempty"`
+
+	Servers []*Server `json:"servers"`
+}
+
+// ServiceDescription describes a logical service: the server set of its
+// primary ECS service and those of its canaries.
+type ServiceDescription struct {
+	Name string `json:"name"`
+
+	// Hosts are the host names the service is reachable at.
+	Hosts []string `json:"hosts,omitempty"`
+
+	ServerSets []*ServerSet `json:"serverSets"`
+
+	// Stale is set if the description is the last known good description,
+	// served because the AWS ECS API failed.
+	Stale bool `json:"stale,omitempty"`
+}
+
+// Servers returns the servers of all server sets.
+func (d *ServiceDescription) Servers() []*Server {
+	var servers []*Server
+
+	for _, set := range d.ServerSets {
+		servers = append(servers, set.Servers...)
+	}
+
+	return servers
+}
+
+// URLs returns the URLs of all servers of the service.
+func (d *ServiceDescription) URLs() []*url.URL {
+	servers := d.Servers()
+
+	urls := make([]*url.URL, 0, len(servers))
+
+	for _, server := range servers {
+		urls = append(urls, server.URL)
+	}
+
+	return urls
+}
+
+// Service converts the service description to a domain service. As a domain
+// service has no notion of weights, the URLs of the servers are repeated in
+// proportion to the weight of their server set so that a round-robin balancer
+// distributes the traffic accordingly. Server sets without weight are left out.
+// If the exact distribution needs more than MaxWeightedURLs URLs, it is
+// approximated using about MaxWeightedURLs URLs.
+func (d *ServiceDescription) Service() *services.Service {
+	return &services.Service{
+		Name:    d.Name,
+		Servers: d.weightedURLs(),
+	}
+}
+
+// MaxWeightedURLs is the number of URLs above which the weights of the server
+// sets of a domain service are approximated.
+const MaxWeightedURLs = 1000
+
+func (d *ServiceDescription) weightedURLs() []*url.URL {
+	if len(d.ServerSets) == 1 {
+		return d.URLs()
+	}
+
+	repeats := d.exactRepeats()
+
+	total := 0
+	for i, set := range d.ServerSets {
+		total += repeats[i] * len(set.Servers)
+	}
+
+	if total > MaxWeightedURLs {
+		repeats = d.approximateRepeats()
+	}
+
+	var urls []*url.URL
+
+	for i, set := range d.ServerSets {
+		for n := 0; n < repeats[i]; n++ {
+			for _, server := range set.Servers {
+				urls = append(urls, server.URL)
+			}
+		}
+	}
+
+	return urls
+}
+
+// exactRepeats returns the smallest number of times the servers of each set
+// must be repeated to distribute the traffic exactly according to the
+// weights.
+func (d *ServiceDescription) exactRepeats() []int {
+	// the number of times the servers of each set are repeated must be
+	// proportional to weight / number of servers
+	l := 1
+	for _, set := range d.ServerSets {
+		if set.Weight > 0 && len(set.Servers) > 0 {
+			l = lcm(l, len(set.Servers))
+		}
+	}
+
+	repeats := make([]int, len(d.ServerSets))
+	g := 0
+
+	for i, set := range d.ServerSets {
+		if set.Weight > 0 && len(set.Servers) > 0 {
+			repeats[i] = set.Weight * l / len(set.Servers)
+			g = gcd(g, repeats[i])
+		}
+	}
+
+	for i := range repeats {
+		if repeats[i] > 0 {
+			repeats[i] /= g
+		}
+	}
+
+	return repeats
+}
+
+// approximateRepeats returns the number of times the servers of each set are
+// repeated to distribute the traffic according to the weights using about
+// MaxWeightedURLs URLs. Every server of a set with weight is included at
+// least once, so a set with many servers and a small weight may receive
+// slightly more traffic than its weight.
+func (d *ServiceDescription) approximateRepeats() []int {
+	totalWeight := 0
+	for _, set := range d.ServerSets {
+		if set.Weight > 0 && len(set.Servers) > 0 {
+			totalWeight += set.Weight
+		}
+	}
+
+	repeats := make([]int, len(d.ServerSets))
+
+	for i, set := range d.ServerSets {
+		if set.Weight > 0 && len(set.Servers) > 0 {
+			share := float64(MaxWeightedURLs) * float64(set.Weight) / float64(totalWeight)
+
+			repeats[i] = int(math.Round(share / float64(len(set.Servers))))
+			if repeats[i] < 1 {
+				repeats[i] = 1
+			}
+		}
+	}
+
+	return repeats
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+
+	return a
+}
+
+func lcm(a, b int) int {
+	return a / gcd(a, b) * b
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newServerSet(service string, weight, servers int) *ServerSet {
+	set := &ServerSet{
+		Service: service,
+		Weight:  weight,
+	}
+
+	for i := 0; i < servers; i++ {
+		u, _ := url.Parse(fmt.Sprintf("http://%s-%d:8080", service, i))
+		set.Servers = append(set.Servers, &Server{URL: u})
+	}
+
+	return set
+}
+
+func countURLs(urls []*url.URL) map[string]int {
+	counts := make(map[string]int)
+
+	for _, u := range urls {
+		counts[u.String()]++
+	}
+
+	return counts
+}
+
+func TestServiceDescriptionServiceWithSingleServerSet(t *testing.T) {
+	desc := &ServiceDescription{
+		Name:       "service1",
+		ServerSets: []*ServerSet{newServerSet("primary", TotalWeight, 2)},
+	}
+
+	svc := desc.Service()
+	assert.Equal(t, "service1", svc.Name)
+	assert.EqualValues(t, desc.URLs(), svc.Servers)
+}
+
+func TestServiceDescriptionServiceRepeatsURLsByWeight(t *testing.T) {
+	desc := &ServiceDescription{
+		Name: "service1",
+		ServerSets: []*ServerSet{
+			newServerSet("primary", 75, 3),
+			newServerSet("canary", 25, 2),
+			newServerSet("disabled", 0, 1),
+		},
+	}
+
+	// primary servers get 25% each, canary servers 12.5% each
+	assert.EqualValues(t, map[string]int{
+		"http://primary-0:8080": 2,
+		"http://primary-1:8080": 2,
+		"http://primary-2:8080": 2,
+		"http://canary-0:8080":  1,
+		"http://canary-1:8080":  1,
+	}, countURLs(desc.Service().Servers))
+}
+
+func TestServiceDescriptionServiceApproximatesLargeWeightedURLs(t *testing.T) {
+	desc := &ServiceDescription{
+		Name: "service1",
+		ServerSets: []*ServerSet{
+			newServerSet("primary", 97, 37),
+			newServerSet("canary", 3, 41),
+		},
+	}
+
+	urls := desc.Service().Servers
+	assert.True(t, len(urls) <= MaxWeightedURLs+41, "urls: %d", len(urls))
+
+	counts := countURLs(urls)
+	assert.Len(t, counts, 37+41)
+
+	canary := 0
+	for u, n := range counts {
+		if strings.HasPrefix(u, "http://canary") {
+			canary += n
+		}
+	}
+
+	// every canary server is included once, which is slightly more than 3%
+	assert.InDelta(t, 0.03, float64(canary)/float64(len(urls)), 0.015)
+}
+
+func TestServiceDescriptionJSON(t *testing.T) {
+	desc := &ServiceDescription{
+		Name: "service1",
+		ServerSets: []*ServerSet{
+			newServerSet("primary", TotalWeight, 2),
+		},
+	}
+
+	desc.ServerSets[0].Labels = map[string]string{DefaultDockerLabelPort: "8080"}
+
+	for _, server := range desc.Servers() {
+		server.Transport.Protocol = ProtocolGRPC
+		server.HealthCheck = DefaultHealthCheck
+		server.TaskDefinition = "taskDef1"
+	}
+
+	data, err := json.Marshal(desc)
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), `"url":"http://primary-0:8080"`)
+	assert.Contains(t, string(data), `"interval":"30s"`)
+
+	var decoded ServiceDescription
+
+	err = json.Unmarshal(data, &decoded)
+	assert.Nil(t, err)
+	assert.EqualValues(t, desc, &decoded)
+}
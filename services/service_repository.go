@@ -21,10 +21,20 @@
 package services
 
 import (
+	"context"
 	"fmt"
+	"net/url"
+	"path"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/off-sync/platform-proxy-aws/interfaces"
 	"github.com/off-sync/platform-proxy-domain/services"
@@ -37,18 +47,129 @@ type ServiceRepository struct {
 	api interfaces.AwsEcsAPI
 
 	// Configuration
-	serverContainerName string
-	dockerLabelPort     string
-	defaultPort         int
+	serverContainerNames []string
+	dockerLabelExpose    string
+	dockerLabelPort      string
+	dockerLabelWeight    string
+	dockerLabelCanaryOf  string
+	dockerLabelScheme    string
+	dockerLabelProtocol  string
+	dockerLabelTLSName   string
+	dockerLabelTLSSkip   string
+	dockerLabelPorts     string
+	dockerLabelHealth    string
+	dockerLabelHosts     string
+	defaultHealthCheck   HealthCheck
+	defaultPort          int
+	deploymentPolicy     DeploymentPolicy
+	canaryGrouping       bool
+	canaryErrorHandler   CanaryErrorHandler
+	namedPorts           bool
+	serverFilters        []ServerFilter
+	tracer               trace.Tracer
+	maxStaleness         time.Duration
+	staleHandler         StaleHandler
+	workers              int
+
+	// Discovery state
+	mutex         sync.Mutex
+	lastDiscovery time.Time
+	lastNames     []string
+	states        map[string]*ServiceState
+	restored      bool
+
+	// canaries are the canary services indexed by the last listing, and
+	// canariesIndexed whether they have been indexed yet.
+	canaries        []*indexedService
+	canariesIndexed bool
+	indexMutex      sync.Mutex
+
+	// restoredDiscovery is the time the services of a restored snapshot
+	// were listed.
+	restoredDiscovery time.Time
 }
 
 // Default values for the ServiceRepository struct.
 const (
 	DefaultServerContainerName = "server"
+	DefaultDockerLabelExpose   = "com.off-sync.platform.proxy.expose"
 	DefaultDockerLabelPort     = "com.off-sync.platform.proxy.port"
+	DefaultDockerLabelWeight   = "com.off-sync.platform.proxy.weight"
+	DefaultDockerLabelCanaryOf = "com.off-sync.platform.proxy.canary-of"
+	DefaultDockerLabelScheme   = "com.off-sync.platform.proxy.scheme"
+	DefaultDockerLabelProtocol = "com.off-sync.platform.proxy.protocol"
+	DefaultDockerLabelTLSName  = "com.off-sync.platform.proxy.tls.server-name"
+	DefaultDockerLabelTLSSkip  = "com.off-sync.platform.proxy.tls.skip-verify"
+	DefaultDockerLabelPorts    = "com.off-sync.platform.proxy.ports."
+	DefaultDockerLabelHealth   = "com.off-sync.platform.proxy.healthcheck."
+	DefaultDockerLabelHosts    = "com.off-sync.platform.proxy.hosts"
+	DefaultScheme              = "http"
+	DefaultProtocol            = ProtocolHTTP1
 	DefaultDefaultPort         = 8080
+	DefaultDeploymentPolicy    = DeploymentPolicyAll
+)
+
+// PortNameSeparator separates the ECS service name and the port name in the
+// name of a logical service exposing a named port, e.g. "service#admin".
+const PortNameSeparator = "#"
+
+// TotalWeight is the total weight of the server sets of a service. Weights are
+// percentages of the traffic.
+const TotalWeight = 100
+
+// DeploymentPolicy determines which deployments of a service receive traffic
+// during a rolling update.
+type DeploymentPolicy int
+
+// Health check docker labels, relative to the health check label prefix.
+const (
+	HealthCheckLabelPath               = "path"
+	HealthCheckLabelInterval           = "interval"
+	HealthCheckLabelTimeout            = "timeout"
+	HealthCheckLabelStatus             = "status"
+	HealthCheckLabelUnhealthyThreshold = "unhealthy-threshold"
+)
+
+// Deployment policies.
+const (
+	// DeploymentPolicyAll routes to the servers of all PRIMARY and ACTIVE
+	// deployments with running tasks.
+	DeploymentPolicyAll DeploymentPolicy = iota
+
+	// DeploymentPolicyPrimaryWhenReady routes only to the PRIMARY deployment
+	// once its running count has reached its desired count. Until then the
+	// servers of all PRIMARY and ACTIVE deployments with running tasks are
+	// used.
+	DeploymentPolicyPrimaryWhenReady
+)
+
+// ParseDeploymentPolicy parses the textual representation of a deployment
+// policy: "all" or "primary-when-ready".
+func ParseDeploymentPolicy(s string) (DeploymentPolicy, error) {
+	switch s {
+	case "all":
+		return DeploymentPolicyAll, nil
+	case "primary-when-ready":
+		return DeploymentPolicyPrimaryWhenReady, nil
+	default:
+		return DeploymentPolicyAll, fmt.Errorf("invalid deployment policy: %s", s)
+	}
+}
+
+// Deployment statuses of an ECS service. The PRIMARY deployment is the most
+// recent one, ACTIVE deployments are still running tasks being replaced.
+const (
+	DeploymentStatusPrimary = "PRIMARY"
+	DeploymentStatusActive  = "ACTIVE"
 )
 
+// ServerFilter filters the servers of a service, e.g. to leave out servers
+// that are known to be unhealthy.
+type ServerFilter interface {
+	// FilterServers returns the servers that should receive traffic.
+	FilterServers(servers []*Server) []*Server
+}
+
 // ServiceRepositoryOption defines the type used to further configure a
 // ServiceRepository.
 type ServiceRepositoryOption func(*ServiceRepository) error
@@ -57,10 +178,25 @@ type ServiceRepositoryOption func(*ServiceRepository) error
 // AWS ECS API.
 func NewServiceRepository(api interfaces.AwsEcsAPI, options ...ServiceRepositoryOption) (*ServiceRepository, error) {
 	r := &ServiceRepository{
-		api:                 api,
-		serverContainerName: DefaultServerContainerName,
-		dockerLabelPort:     DefaultDockerLabelPort,
-		defaultPort:         DefaultDefaultPort,
+		api:                  api,
+		serverContainerNames: []string{DefaultServerContainerName},
+		dockerLabelExpose:    DefaultDockerLabelExpose,
+		dockerLabelPort:      DefaultDockerLabelPort,
+		dockerLabelWeight:    DefaultDockerLabelWeight,
+		dockerLabelCanaryOf:  DefaultDockerLabelCanaryOf,
+		dockerLabelScheme:    DefaultDockerLabelScheme,
+		dockerLabelProtocol:  DefaultDockerLabelProtocol,
+		dockerLabelTLSName:   DefaultDockerLabelTLSName,
+		dockerLabelTLSSkip:   DefaultDockerLabelTLSSkip,
+		dockerLabelPorts:     DefaultDockerLabelPorts,
+		dockerLabelHealth:    DefaultDockerLabelHealth,
+		dockerLabelHosts:     DefaultDockerLabelHosts,
+		defaultHealthCheck:   DefaultHealthCheck,
+		defaultPort:          DefaultDefaultPort,
+		deploymentPolicy:     DefaultDeploymentPolicy,
+		workers:              DefaultWorkers,
+		states:               make(map[string]*ServiceState),
+		tracer:               otel.Tracer(TracerName),
 	}
 
 	for _, opt := range options {
@@ -76,8 +212,31 @@ func NewServiceRepository(api interfaces.AwsEcsAPI, options ...ServiceRepository
 // WithServerContainerName configures a service repository with the provided
 // server container name.
 func WithServerContainerName(name string) ServiceRepositoryOption {
+	return WithServerContainerNames(name)
+}
+
+// WithServerContainerNames configures a service repository with the provided
+// server container names. Names may be glob patterns as supported by
+// path.Match, e.g. "web-*".
+func WithServerContainerNames(names ...string) ServiceRepositoryOption {
+	return func(r *ServiceRepository) error {
+		for _, name := range names {
+			if _, err := path.Match(name, ""); err != nil {
+				return fmt.Errorf("invalid server container name pattern: %s", name)
+			}
+		}
+
+		r.serverContainerNames = names
+		return nil
+	}
+}
+
+// WithDockerLabelExpose configures a service repository with the provided
+// docker label that marks a container as a server container, regardless of
+// its name.
+func WithDockerLabelExpose(label string) ServiceRepositoryOption {
 	return func(r *ServiceRepository) error {
-		r.serverContainerName = name
+		r.dockerLabelExpose = label
 		return nil
 	}
 }
@@ -91,6 +250,136 @@ func WithDockerLabelPort(label string) ServiceRepositoryOption {
 	}
 }
 
+// WithDockerLabelWeight configures a service repository with the provided
+// docker label for the weight of a service.
+func WithDockerLabelWeight(label string) ServiceRepositoryOption {
+	return func(r *ServiceRepository) error {
+		r.dockerLabelWeight = label
+		return nil
+	}
+}
+
+// WithDockerLabelCanaryOf configures a service repository with the provided
+// docker label that marks a service as a canary of another service.
+func WithDockerLabelCanaryOf(label string) ServiceRepositoryOption {
+	return func(r *ServiceRepository) error {
+		r.dockerLabelCanaryOf = label
+		return nil
+	}
+}
+
+// WithDockerLabelScheme configures a service repository with the provided
+// docker label for the scheme (http or https) of the server.
+func WithDockerLabelScheme(label string) ServiceRepositoryOption {
+	return func(r *ServiceRepository) error {
+		r.dockerLabelScheme = label
+		return nil
+	}
+}
+
+// WithDockerLabelProtocol configures a service repository with the provided
+// docker label for the protocol spoken by the server.
+func WithDockerLabelProtocol(label string) ServiceRepositoryOption {
+	return func(r *ServiceRepository) error {
+		r.dockerLabelProtocol = label
+		return nil
+	}
+}
+
+// WithDockerLabelTLSServerName configures a service repository with the
+// provided docker label for the TLS server name of the server.
+func WithDockerLabelTLSServerName(label string) ServiceRepositoryOption {
+	return func(r *ServiceRepository) error {
+		r.dockerLabelTLSName = label
+		return nil
+	}
+}
+
+// WithDockerLabelTLSSkipVerify configures a service repository with the
+// provided docker label that disables verification of the server certificate.
+func WithDockerLabelTLSSkipVerify(label string) ServiceRepositoryOption {
+	return func(r *ServiceRepository) error {
+		r.dockerLabelTLSSkip = label
+		return nil
+	}
+}
+
+// WithDockerLabelPorts configures a service repository with the provided
+// docker label prefix for named ports. A label consisting of the prefix
+// followed by a port name exposes that port as a separate logical service.
+func WithDockerLabelPorts(prefix string) ServiceRepositoryOption {
+	return func(r *ServiceRepository) error {
+		r.dockerLabelPorts = prefix
+		return nil
+	}
+}
+
+// WithDockerLabelHosts configures a service repository with the provided
+// docker label for the comma separated host names of a service. The host
+// names of a named port are configured using the label followed by a dot and
+// the port name.
+func WithDockerLabelHosts(label string) ServiceRepositoryOption {
+	return func(r *ServiceRepository) error {
+		r.dockerLabelHosts = label
+		return nil
+	}
+}
+
+// WithDockerLabelHealthCheck configures a service repository with the provided
+// docker label prefix for the health check configuration of servers.
+func WithDockerLabelHealthCheck(prefix string) ServiceRepositoryOption {
+	return func(r *ServiceRepository) error {
+		r.dockerLabelHealth = prefix
+		return nil
+	}
+}
+
+// WithNamedPorts configures whether a service repository lists a separate
+// logical service for every named port of a service. Listing named ports
+// requires all services of the cluster to be inspected.
+func WithNamedPorts(enabled bool) ServiceRepositoryOption {
+	return func(r *ServiceRepository) error {
+		r.namedPorts = enabled
+		return nil
+	}
+}
+
+// WithCanaryGrouping configures whether a service repository groups canary
+// services with the service they are a canary of. Grouping requires all
+// services of the cluster to be inspected when listing the services. The
+// canaries found are used when describing services until the next listing.
+func WithCanaryGrouping(enabled bool) ServiceRepositoryOption {
+	return func(r *ServiceRepository) error {
+		r.canaryGrouping = enabled
+		return nil
+	}
+}
+
+// CanaryErrorHandler is called when a canary is left out of the description
+// of the service it is a canary of, because it is misconfigured or cannot be
+// described. It may be called concurrently.
+type CanaryErrorHandler func(name string, err error)
+
+// WithCanaryErrorHandler configures a service repository with the provided
+// handler, which is called whenever a canary is left out.
+func WithCanaryErrorHandler(h CanaryErrorHandler) ServiceRepositoryOption {
+	return func(r *ServiceRepository) error {
+		r.canaryErrorHandler = h
+		return nil
+	}
+}
+
+// WithServerFilters configures a service repository with the provided server
+// filters, which are applied to the servers of every server set in order. If
+// a filter leaves no servers, its result is ignored so that a faulty filter
+// cannot take down a service.
+func WithServerFilters(filters ...ServerFilter) ServiceRepositoryOption {
+	return func(r *ServiceRepository) error {
+		r.serverFilters = append(r.serverFilters, filters...)
+		return nil
+	}
+}
+
 // WithDefaultPort configures a service repository with the provided
 // default port.
 func WithDefaultPort(port int) ServiceRepositoryOption {
@@ -100,51 +389,850 @@ func WithDefaultPort(port int) ServiceRepositoryOption {
 	}
 }
 
-// ListServices returns all service names contained in this repository.
+// WithDefaultHealthCheck configures a service repository with the provided
+// health check for servers without health check configuration.
+func WithDefaultHealthCheck(healthCheck HealthCheck) ServiceRepositoryOption {
+	return func(r *ServiceRepository) error {
+		if !strings.HasPrefix(healthCheck.Path, "/") ||
+			healthCheck.Interval <= 0 ||
+			healthCheck.Timeout <= 0 ||
+			healthCheck.UnhealthyThreshold < 1 {
+			return fmt.Errorf("invalid default health check: %+v", healthCheck)
+		}
+
+		r.defaultHealthCheck = healthCheck
+		return nil
+	}
+}
+
+// WithDeploymentPolicy configures a service repository with the provided
+// deployment policy.
+func WithDeploymentPolicy(policy DeploymentPolicy) ServiceRepositoryOption {
+	return func(r *ServiceRepository) error {
+		switch policy {
+		case DeploymentPolicyAll, DeploymentPolicyPrimaryWhenReady:
+			r.deploymentPolicy = policy
+			return nil
+		default:
+			return fmt.Errorf("invalid deployment policy: %d", policy)
+		}
+	}
+}
+
+// ListServices returns all service names contained in this repository. When
+// canary grouping is enabled, canary services are left out as they are part
+// of the service they are a canary of. When named ports are enabled, services
+// with named ports are listed once for every port name.
+//
+// If a maximum staleness is configured and the ECS API fails, the services
+// of the last successful listing are returned if it is recent enough.
 func (r *ServiceRepository) ListServices() ([]string, error) {
-	return r.api.ListServices()
+	return r.ListServicesWithContext(context.Background())
+}
+
+// ListServicesWithContext is ListServices using the provided context, which
+// is passed on to the AWS ECS API calls. Stale data is not served once the
+// context is cancelled.
+func (r *ServiceRepository) ListServicesWithContext(ctx context.Context) ([]string, error) {
+	ctx, span := r.tracer.Start(ctx, "ServiceRepository.ListServices")
+	defer span.End()
+
+	if names, found := r.restoredServices(); found {
+		return names, nil
+	}
+
+	names, err := r.listServicesAndRecord(ctx)
+	if err != nil && isAPIError(err) && ctx.Err() != context.Canceled {
+		if stale, found := r.staleServices(err); found {
+			return stale, nil
+		}
+	}
+
+	err = unmarkAPIError(err)
+	endSpan(span, err)
+
+	return names, err
+}
+
+// listServicesAndRecord lists the services and records the result.
+func (r *ServiceRepository) listServicesAndRecord(ctx context.Context) ([]string, error) {
+	names, err := r.listServices(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	r.recordDiscovery(names)
+
+	return names, nil
+}
+
+// LastDiscovery returns the time services were last listed successfully, or
+// the zero time if that has not happened yet.
+func (r *ServiceRepository) LastDiscovery() time.Time {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.lastDiscovery
+}
+
+func (r *ServiceRepository) listServices(ctx context.Context) ([]string, error) {
+	names, err := r.listECSServices(ctx)
+	if err != nil || !(r.canaryGrouping || r.namedPorts) {
+		return names, err
+	}
+
+	index, err := r.indexServices(ctx, names)
+	if err != nil {
+		return nil, err
+	}
+
+	r.recordIndex(index)
+
+	var logicalNames []string
+
+	for _, service := range index {
+		if service.err != nil {
+			// the error is reported when the service is described
+			logicalNames = append(logicalNames, service.name)
+			continue
+		}
+
+		if _, isCanary := service.labels[r.dockerLabelCanaryOf]; isCanary && r.canaryGrouping {
+			continue
+		}
+
+		portNames := r.getPortNames(service.labels)
+		if !r.namedPorts || len(portNames) < 1 {
+			logicalNames = append(logicalNames, service.name)
+			continue
+		}
+
+		for _, portName := range portNames {
+			logicalNames = append(logicalNames, service.name+PortNameSeparator+portName)
+		}
+	}
+
+	return logicalNames, nil
+}
+
+// getPortNames returns the sorted names of the named ports in the labels.
+func (r *ServiceRepository) getPortNames(labels map[string]*string) []string {
+	var portNames []string
+
+	for label := range labels {
+		if strings.HasPrefix(label, r.dockerLabelPorts) {
+			portNames = append(portNames, strings.TrimPrefix(label, r.dockerLabelPorts))
+		}
+	}
+
+	sort.Strings(portNames)
+
+	return portNames
+}
+
+// splitServiceName splits the name of a logical service into the name of the
+// ECS service and the port name, which is empty for the default port.
+func splitServiceName(name string) (string, string) {
+	i := strings.LastIndex(name, PortNameSeparator)
+	if i < 0 {
+		return name, ""
+	}
+
+	return name[:i], name[i+len(PortNameSeparator):]
 }
 
 // DescribeService returns the service with the specified name. If no service
 // exists with that name an ErrUnknownService is returned.
 func (r *ServiceRepository) DescribeService(name string) (*services.Service, error) {
-	service, err := r.api.DescribeService(name)
+	return r.DescribeServiceWithContext(context.Background(), name)
+}
+
+// DescribeServiceWithContext is DescribeService using the provided context.
+func (r *ServiceRepository) DescribeServiceWithContext(ctx context.Context, name string) (*services.Service, error) {
+	ctx, span := r.tracer.Start(ctx, "ServiceRepository.DescribeService",
+		trace.WithAttributes(AttributeService.String(name)))
+	defer span.End()
+
+	desc, err := r.describeServiceDetailsOrStale(ctx, name)
+	if err != nil {
+		endSpan(span, err)
+		return nil, err
+	}
+
+	return desc.Service(), nil
+}
+
+// DescribeServiceDetails returns the description of the service with the
+// specified name. The servers of every deployment selected by the deployment
+// policy are resolved separately and tagged with their deployment. When
+// canary grouping is enabled, the server sets of its canaries are included.
+// Misconfigured canaries are left out and reported to the canary error
+// handler.
+// The name of a service exposing a named port consists of the name of the ECS
+// service, the PortNameSeparator and the port name.
+//
+// If a maximum staleness is configured and the ECS API fails, the last known
+// description of the service is returned, marked as stale, if it is recent
+// enough.
+func (r *ServiceRepository) DescribeServiceDetails(name string) (*ServiceDescription, error) {
+	return r.DescribeServiceDetailsWithContext(context.Background(), name)
+}
+
+// DescribeServiceDetailsWithContext is DescribeServiceDetails using the
+// provided context, which is passed on to the AWS ECS API calls. Stale data
+// is not served once the context is cancelled.
+func (r *ServiceRepository) DescribeServiceDetailsWithContext(ctx context.Context, name string) (*ServiceDescription, error) {
+	ctx, span := r.tracer.Start(ctx, "ServiceRepository.DescribeServiceDetails",
+		trace.WithAttributes(AttributeService.String(name)))
+	defer span.End()
+
+	desc, err := r.describeServiceDetailsOrStale(ctx, name)
+	endSpan(span, err)
+
+	return desc, err
+}
+
+// describeServiceDetailsOrStale describes the service, falling back to its
+// last known description if the ECS API fails.
+func (r *ServiceRepository) describeServiceDetailsOrStale(ctx context.Context, name string) (*ServiceDescription, error) {
+	if desc := r.restoredDescription(name); desc != nil {
+		return desc, nil
+	}
+
+	desc, err := r.describeServiceDetailsAndRecord(ctx, name)
+	if err != nil && isAPIError(err) && ctx.Err() != context.Canceled {
+		if stale := r.staleDescription(name, err); stale != nil {
+			return stale, nil
+		}
+	}
+
+	return desc, unmarkAPIError(err)
+}
+
+// describeServiceDetailsAndRecord describes the service and records the result
+// in its state. Errors caused by cancelling the context are not recorded.
+func (r *ServiceRepository) describeServiceDetailsAndRecord(ctx context.Context, name string) (*ServiceDescription, error) {
+	desc, err := r.describeServiceDetails(ctx, name)
+
+	if err == nil || ctx.Err() != context.Canceled {
+		r.recordDescription(name, desc, unmarkAPIError(err))
+	}
+
+	return desc, err
+}
+
+func (r *ServiceRepository) describeServiceDetails(ctx context.Context, name string) (*ServiceDescription, error) {
+	desc, canaryErrs, err := r.resolveServiceDetails(ctx, name)
+
+	if r.canaryErrorHandler != nil {
+		for _, canaryErr := range canaryErrs {
+			r.canaryErrorHandler(name, unmarkAPIError(canaryErr))
+		}
+	}
+
+	return desc, err
+}
+
+// resolveServiceDetails describes the service. Canaries that cannot be
+// included are left out and their errors are returned separately, so that a
+// misconfigured canary cannot take down the service.
+func (r *ServiceRepository) resolveServiceDetails(ctx context.Context, name string) (*ServiceDescription, []error, error) {
+	ecsName, portName := splitServiceName(name)
+
+	service, err := r.describeECSService(ctx, ecsName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	set, labels, err := r.describeServerSet(ctx, ecsName, portName, service)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	desc := &ServiceDescription{
+		Name:       name,
+		Hosts:      r.getHosts(labels, portName),
+		ServerSets: []*ServerSet{set},
+	}
+
+	if !r.canaryGrouping {
+		set.Weight = TotalWeight
+		return desc, nil, nil
+	}
+
+	set.Weight = -1
+
+	if label, found := labels[r.dockerLabelWeight]; found {
+		set.Weight, err = parseWeight(aws.StringValue(label))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	available := TotalWeight
+	if set.Weight >= 0 {
+		available -= set.Weight
+	}
+
+	canaries, canaryErrs, err := r.describeCanaries(ctx, ecsName, portName, service, available)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	desc.ServerSets = append(desc.ServerSets, canaries...)
+
+	if set.Weight < 0 {
+		set.Weight = TotalWeight
+		for _, canary := range canaries {
+			set.Weight -= canary.Weight
+		}
+	}
+
+	return desc, canaryErrs, nil
+}
+
+// describeCanaries returns the server sets of all services that are a canary
+// of the provided service, using the provided port name. The canary services
+// are taken from the index of the last listing.
+//
+// Canaries must have a weight, as they would not receive any traffic
+// otherwise, and their weights must not add up to more than the available
+// weight. Canaries that do not meet these requirements or cannot be described
+// are left out and their errors are returned.
+func (r *ServiceRepository) describeCanaries(ctx context.Context, name, portName string, primary *ecs.Service, available int) ([]*ServerSet, []error, error) {
+	candidates, err := r.canaryServices(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var canaries []*ServerSet
+	var canaryErrs []error
+
+	for _, candidate := range candidates {
+		if !r.isCanaryOf(candidate, name, primary) {
+			continue
+		}
+
+		set, err := r.describeCanary(ctx, candidate, portName, available)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, nil, ctxErr
+			}
+
+			canaryErrs = append(canaryErrs, fmt.Errorf("canary %s of service %s: %s", candidate.name, name, unmarkAPIError(err)))
+			continue
+		}
+
+		available -= set.Weight
+		canaries = append(canaries, set)
+	}
+
+	return canaries, canaryErrs, nil
+}
+
+// describeCanary returns the server set of the canary for the provided port
+// name, if its weight does not exceed the available weight.
+func (r *ServiceRepository) describeCanary(ctx context.Context, canary *indexedService, portName string, available int) (*ServerSet, error) {
+	label, found := canary.labels[r.dockerLabelWeight]
+	if !found {
+		return nil, fmt.Errorf("no weight")
+	}
+
+	weight, err := parseWeight(aws.StringValue(label))
 	if err != nil {
 		return nil, err
 	}
 
-	serverURL, err := r.getTaskDefinitionServerURL(aws.StringValue(service.TaskDefinition))
+	if weight > available {
+		return nil, fmt.Errorf("weight %d exceeds the remaining weight %d of %d", weight, available, TotalWeight)
+	}
+
+	set, _, err := r.describeServerSet(ctx, canary.name, portName, canary.service)
 	if err != nil {
 		return nil, err
 	}
 
-	return services.NewService(name, serverURL)
+	set.Weight = weight
+
+	return set, nil
+}
+
+// getHosts returns the host names of the service, or of the named port, from
+// the labels. Host names are lower cased and duplicates are left out.
+func (r *ServiceRepository) getHosts(labels map[string]*string, portName string) []string {
+	label := r.dockerLabelHosts
+	if portName != "" {
+		label += "." + portName
+	}
+
+	value, found := labels[label]
+	if !found {
+		return nil
+	}
+
+	var hosts []string
+
+	seen := make(map[string]bool)
+
+	for _, host := range strings.Split(aws.StringValue(value), ",") {
+		host = strings.ToLower(strings.TrimSpace(host))
+		if host == "" || seen[host] {
+			continue
+		}
+
+		seen[host] = true
+		hosts = append(hosts, host)
+	}
+
+	return hosts
+}
+
+// isService returns whether the reference matches the name, ARN or service
+// name of the service.
+func isService(ref, name string, service *ecs.Service) bool {
+	return ref == name ||
+		ref == aws.StringValue(service.ServiceArn) ||
+		ref == aws.StringValue(service.ServiceName)
+}
+
+func parseWeight(label string) (int, error) {
+	weight, err := strconv.Atoi(label)
+	if err != nil || weight < 0 || weight > TotalWeight {
+		return 0, fmt.Errorf("invalid weight: %s", label)
+	}
+
+	return weight, nil
 }
 
-func (r *ServiceRepository) getTaskDefinitionServerURL(taskDefArn string) (string, error) {
-	tdef, err := r.api.DescribeTaskDefinition(taskDefArn)
+// describeServerSet resolves the servers of the ECS service for the provided
+// port name. It also returns the docker labels of the server containers of the
+// primary deployment, or of the first deployment if the primary deployment is
+// not selected.
+func (r *ServiceRepository) describeServerSet(ctx context.Context, name, portName string, service *ecs.Service) (*ServerSet, map[string]*string, error) {
+	set := &ServerSet{Service: name}
+
+	var labels map[string]*string
+
+	// deployments may share host names, e.g. when only the image changed:
+	// their servers are routed once, preferring the primary deployment
+	indexes := make(map[string]int)
+
+	for _, deployment := range r.selectDeployments(service) {
+		servers, deploymentLabels, err := r.getDeploymentServers(ctx, deployment, portName)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		isPrimary := aws.StringValue(deployment.Status) == DeploymentStatusPrimary
+
+		if labels == nil || isPrimary {
+			labels = deploymentLabels
+		}
+
+		for _, server := range servers {
+			i, found := indexes[server.URL.String()]
+			if !found {
+				indexes[server.URL.String()] = len(set.Servers)
+				set.Servers = append(set.Servers, server)
+				continue
+			}
+
+			if isPrimary {
+				set.Servers[i] = server
+			}
+		}
+	}
+
+	if len(labels) > 0 {
+		set.Labels = aws.StringValueMap(labels)
+	}
+
+	set.Servers = r.filterServers(set.Servers)
+
+	return set, labels, nil
+}
+
+// filterServers applies the server filters to the servers in order, ignoring
+// the result of a filter that leaves no servers.
+func (r *ServiceRepository) filterServers(servers []*Server) []*Server {
+	for _, filter := range r.serverFilters {
+		if filtered := filter.FilterServers(servers); len(filtered) > 0 {
+			servers = filtered
+		}
+	}
+
+	return servers
+}
+
+// getServiceLabels returns the docker labels of the server containers of the
+// task definition of the service.
+func (r *ServiceRepository) getServiceLabels(ctx context.Context, service *ecs.Service) (map[string]*string, error) {
+	tdef, err := r.describeECSTaskDefinition(ctx, aws.StringValue(service.TaskDefinition))
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	cdefs, err := r.getServerContainers(tdef)
+	if err != nil {
+		return nil, err
+	}
+
+	return mergeLabels(cdefs), nil
+}
+
+// mergeLabels merges the docker labels of the containers. If containers define
+// the same label, the value of the first container is used.
+func mergeLabels(cdefs []*ecs.ContainerDefinition) map[string]*string {
+	labels := make(map[string]*string)
+
+	for _, cdef := range cdefs {
+		for label, value := range cdef.DockerLabels {
+			if _, found := labels[label]; !found {
+				labels[label] = value
+			}
+		}
 	}
 
+	return labels
+}
+
+// selectDeployments returns the deployments of the service that should receive
+// traffic according to the deployment policy. Only PRIMARY and ACTIVE
+// deployments with running tasks are selected; if there are none, the PRIMARY
+// deployment is selected so that the service keeps its servers. Services
+// without deployment information are treated as having a single primary
+// deployment of their task definition.
+func (r *ServiceRepository) selectDeployments(service *ecs.Service) []*ecs.Deployment {
+	if len(service.Deployments) < 1 {
+		return []*ecs.Deployment{&ecs.Deployment{
+			Status:         aws.String(DeploymentStatusPrimary),
+			TaskDefinition: service.TaskDefinition,
+		}}
+	}
+
+	var running, primary []*ecs.Deployment
+
+	for _, deployment := range service.Deployments {
+		switch aws.StringValue(deployment.Status) {
+		case DeploymentStatusPrimary:
+			primary = append(primary, deployment)
+		case DeploymentStatusActive:
+		default:
+			// e.g. INACTIVE deployments
+			continue
+		}
+
+		if aws.Int64Value(deployment.RunningCount) > 0 {
+			running = append(running, deployment)
+		}
+	}
+
+	if r.deploymentPolicy == DeploymentPolicyPrimaryWhenReady {
+		for _, deployment := range primary {
+			if aws.Int64Value(deployment.RunningCount) >= aws.Int64Value(deployment.DesiredCount) {
+				return []*ecs.Deployment{deployment}
+			}
+		}
+	}
+
+	if len(running) < 1 {
+		return primary
+	}
+
+	return running
+}
+
+// getDeploymentServers returns a server for every server container of the
+// deployment exposing the provided port name, together with the merged docker
+// labels of its server containers.
+func (r *ServiceRepository) getDeploymentServers(ctx context.Context, deployment *ecs.Deployment, portName string) ([]*Server, map[string]*string, error) {
+	tdef, err := r.describeECSTaskDefinition(ctx, aws.StringValue(deployment.TaskDefinition))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cdefs, err := r.getServerContainers(tdef)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var servers []*Server
+
+	for _, cdef := range cdefs {
+		if portName != "" && cdef.DockerLabels[r.dockerLabelPorts+portName] == nil {
+			// port not exposed by this container
+			continue
+		}
+
+		serverURL, err := r.getTaskDefinitionServerURL(ctx, aws.StringValue(deployment.TaskDefinition), cdef, portName)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		u, err := url.Parse(serverURL)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		transport, err := r.getServerTransport(u.Scheme, cdef)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		healthCheck, err := r.getServerHealthCheck(cdef)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		servers = append(servers, &Server{
+			URL:              u,
+			Transport:        transport,
+			Container:        aws.StringValue(cdef.Name),
+			HealthCheck:      healthCheck,
+			DeploymentID:     aws.StringValue(deployment.Id),
+			DeploymentStatus: aws.StringValue(deployment.Status),
+			TaskDefinition:   aws.StringValue(deployment.TaskDefinition),
+			Revision:         aws.Int64Value(tdef.Revision),
+		})
+	}
+
+	if len(servers) < 1 {
+		return nil, nil, fmt.Errorf("unknown port name: %s", portName)
+	}
+
+	return servers, mergeLabels(cdefs), nil
+}
+
+// getServerContainers returns the containers of the task definition that are
+// server containers: those with a name matching one of the server container
+// names, or with the expose label set to true. The expose label takes
+// precedence over the name, so it can also be used to exclude a container.
+func (r *ServiceRepository) getServerContainers(tdef *ecs.TaskDefinition) ([]*ecs.ContainerDefinition, error) {
+	var cdefs []*ecs.ContainerDefinition
+
 	for _, cdef := range tdef.ContainerDefinitions {
-		if aws.StringValue(cdef.Name) != r.serverContainerName {
-			// not the server
+		isServer, err := r.isServerContainer(cdef)
+		if err != nil {
+			return nil, err
+		}
+
+		if isServer {
+			cdefs = append(cdefs, cdef)
+		}
+	}
+
+	if len(cdefs) < 1 {
+		return nil, fmt.Errorf("no server container found for task definition: %s", aws.StringValue(tdef.TaskDefinitionArn))
+	}
+
+	return cdefs, nil
+}
+
+func (r *ServiceRepository) isServerContainer(cdef *ecs.ContainerDefinition) (bool, error) {
+	if exposeLabel, found := cdef.DockerLabels[r.dockerLabelExpose]; found {
+		expose, err := strconv.ParseBool(aws.StringValue(exposeLabel))
+		if err != nil {
+			return false, fmt.Errorf("invalid expose: %s", aws.StringValue(exposeLabel))
+		}
+
+		return expose, nil
+	}
+
+	for _, pattern := range r.serverContainerNames {
+		// patterns are validated when configured
+		if matched, _ := path.Match(pattern, aws.StringValue(cdef.Name)); matched {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (r *ServiceRepository) getTaskDefinitionServerURL(ctx context.Context, taskDefArn string, cdef *ecs.ContainerDefinition, portName string) (string, error) {
+	_, span := r.tracer.Start(ctx, "ServiceRepository.getTaskDefinitionServerURL",
+		trace.WithAttributes(
+			AttributeTaskDefinition.String(taskDefArn),
+			AttributeContainer.String(aws.StringValue(cdef.Name))))
+	defer span.End()
+
+	serverURL, err := r.getContainerServerURL(cdef, portName)
+	endSpan(span, err)
+
+	return serverURL, err
+}
+
+func (r *ServiceRepository) getContainerServerURL(cdef *ecs.ContainerDefinition, portName string) (string, error) {
+	port := r.defaultPort
+
+	portLabel, found := cdef.DockerLabels[r.dockerLabelPort]
+	if portName != "" {
+		portLabel, found = cdef.DockerLabels[r.dockerLabelPorts+portName]
+		if !found {
+			return "", fmt.Errorf("unknown port name: %s", portName)
+		}
+	}
+
+	if found {
+		var err error
+
+		port, err = strconv.Atoi(*portLabel)
+		if err != nil {
+			return "", fmt.Errorf("invalid port: %s", *portLabel)
+		}
+	}
+
+	scheme := DefaultScheme
+
+	if schemeLabel, found := cdef.DockerLabels[r.dockerLabelScheme]; found {
+		scheme = aws.StringValue(schemeLabel)
+		if scheme != "http" && scheme != "https" {
+			return "", fmt.Errorf("invalid scheme: %s", scheme)
+		}
+	}
+
+	return fmt.Sprintf("%s://%s:%d", scheme, aws.StringValue(cdef.Hostname), port), nil
+}
+
+// getServerTransport returns the transport settings of the server container.
+func (r *ServiceRepository) getServerTransport(scheme string, cdef *ecs.ContainerDefinition) (Transport, error) {
+	transport := Transport{Protocol: DefaultProtocol}
+
+	if protocolLabel, found := cdef.DockerLabels[r.dockerLabelProtocol]; found {
+		transport.Protocol = Protocol(aws.StringValue(protocolLabel))
+
+		switch transport.Protocol {
+		case ProtocolHTTP1, ProtocolGRPC:
+		case ProtocolH2C:
+			if scheme != "http" {
+				return Transport{}, fmt.Errorf("protocol %s requires scheme http", ProtocolH2C)
+			}
+		default:
+			return Transport{}, fmt.Errorf("invalid protocol: %s", transport.Protocol)
+		}
+	}
+
+	if nameLabel, found := cdef.DockerLabels[r.dockerLabelTLSName]; found {
+		transport.TLSServerName = aws.StringValue(nameLabel)
+	}
+
+	if skipLabel, found := cdef.DockerLabels[r.dockerLabelTLSSkip]; found {
+		skip, err := strconv.ParseBool(aws.StringValue(skipLabel))
+		if err != nil {
+			return Transport{}, fmt.Errorf("invalid TLS skip verify: %s", aws.StringValue(skipLabel))
+		}
+
+		transport.TLSSkipVerify = skip
+	}
+
+	if scheme != "https" && (transport.TLSServerName != "" || transport.TLSSkipVerify) {
+		return Transport{}, fmt.Errorf("TLS settings require scheme https")
+	}
+
+	return transport, nil
+}
+
+// getServerHealthCheck returns the health check configuration of the server
+// container. The health check labels take precedence over the health check
+// of the container definition, which takes precedence over the defaults.
+func (r *ServiceRepository) getServerHealthCheck(cdef *ecs.ContainerDefinition) (HealthCheck, error) {
+	healthCheck := r.defaultHealthCheck
+
+	if cdef.HealthCheck != nil {
+		if cdef.HealthCheck.Interval != nil {
+			healthCheck.Interval = time.Duration(*cdef.HealthCheck.Interval) * time.Second
+		}
+
+		if cdef.HealthCheck.Timeout != nil {
+			healthCheck.Timeout = time.Duration(*cdef.HealthCheck.Timeout) * time.Second
+		}
+
+		if cdef.HealthCheck.Retries != nil {
+			healthCheck.UnhealthyThreshold = int(*cdef.HealthCheck.Retries)
+		}
+
+		if path, found := getHealthCheckCommandPath(cdef.HealthCheck.Command); found {
+			healthCheck.Path = path
+		}
+	}
+
+	for label, value := range cdef.DockerLabels {
+		if !strings.HasPrefix(label, r.dockerLabelHealth) {
 			continue
 		}
 
-		port := r.defaultPort
+		err := setHealthCheckLabel(&healthCheck, strings.TrimPrefix(label, r.dockerLabelHealth), aws.StringValue(value))
+		if err != nil {
+			return HealthCheck{}, fmt.Errorf("invalid health check label %s: %s", label, err)
+		}
+	}
+
+	return healthCheck, nil
+}
+
+func setHealthCheckLabel(healthCheck *HealthCheck, name, value string) error {
+	var err error
+
+	switch name {
+	case HealthCheckLabelPath:
+		if !strings.HasPrefix(value, "/") {
+			return fmt.Errorf("path must start with /: %s", value)
+		}
 
-		portLabel, found := cdef.DockerLabels[r.dockerLabelPort]
-		if found {
-			port, err = strconv.Atoi(*portLabel)
+		healthCheck.Path = value
+	case HealthCheckLabelInterval:
+		healthCheck.Interval, err = parseHealthCheckDuration(value)
+	case HealthCheckLabelTimeout:
+		healthCheck.Timeout, err = parseHealthCheckDuration(value)
+	case HealthCheckLabelStatus:
+		healthCheck.ExpectedStatus, err = strconv.Atoi(value)
+		if err == nil && (healthCheck.ExpectedStatus < 100 || healthCheck.ExpectedStatus > 599) {
+			err = fmt.Errorf("invalid status: %s", value)
+		}
+	case HealthCheckLabelUnhealthyThreshold:
+		healthCheck.UnhealthyThreshold, err = strconv.Atoi(value)
+		if err == nil && healthCheck.UnhealthyThreshold < 1 {
+			err = fmt.Errorf("invalid threshold: %s", value)
+		}
+	default:
+		err = fmt.Errorf("unknown label")
+	}
+
+	return err
+}
+
+// parseHealthCheckDuration parses a duration such as "10s" or a number of
+// seconds, as used by ECS health checks.
+func parseHealthCheckDuration(s string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(s); err == nil {
+		s = fmt.Sprintf("%ds", seconds)
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid duration: %s", s)
+	}
+
+	return d, nil
+}
+
+// getHealthCheckCommandPath returns the path of the first HTTP URL in the
+// health check command of a container definition, e.g. "/health" for
+// ["CMD-SHELL", "curl -f http://localhost:8080/health || exit 1"].
+func getHealthCheckCommandPath(command []*string) (string, bool) {
+	for _, arg := range aws.StringValueSlice(command) {
+		for _, field := range strings.Fields(arg) {
+			if !strings.HasPrefix(field, "http://") && !strings.HasPrefix(field, "https://") {
+				continue
+			}
+
+			u, err := url.Parse(field)
 			if err != nil {
-				return "", fmt.Errorf("invalid port: %s", *portLabel)
+				continue
 			}
-		}
 
-		return fmt.Sprintf("http://%s:%d", *cdef.Hostname, port), nil
+			return u.RequestURI(), true
+		}
 	}
 
-	return "", fmt.Errorf("no server container found for task definition: %s", taskDefArn)
+	return "", false
 }
@@ -1,9 +1,12 @@
 package services
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ecs"
@@ -31,6 +34,8 @@ func TestNewServiceRepository(t *testing.T) {
 func TestNewServiceRepositoryWithOptions(t *testing.T) {
 	setUp(t,
 		WithServerContainerName("name"),
+		WithServerContainerNames("web-*", "api"),
+		WithDockerLabelExpose("label"),
 		WithDockerLabelPort("label"),
 		WithDefaultPort(1234))
 }
@@ -59,6 +64,45 @@ func TestListServices(t *testing.T) {
 	assert.EqualValues(t, []string{"service1", "service2"}, names)
 }
 
+func TestServiceRepositoryWithCancelledContext(t *testing.T) {
+	r, api := setUp(t)
+
+	addService(api, "web", "web", nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := r.ListServicesWithContext(ctx)
+	assert.Equal(t, context.Canceled, err)
+
+	_, err = r.DescribeServiceWithContext(ctx, "web")
+	assert.Equal(t, context.Canceled, err)
+
+	_, err = r.DescribeServiceDetailsWithContext(ctx, "web")
+	assert.Equal(t, context.Canceled, err)
+
+	assert.Equal(t, context.Canceled, r.RefreshWithContext(ctx))
+
+	// errors caused by cancellation are not recorded
+	assert.Len(t, r.Snapshot().Services, 0)
+}
+
+func TestLastDiscovery(t *testing.T) {
+	r, api := setUp(t)
+
+	assert.True(t, r.LastDiscovery().IsZero())
+
+	api.FailListServices = true
+	_, err := r.ListServices()
+	assert.NotNil(t, err)
+	assert.True(t, r.LastDiscovery().IsZero())
+
+	api.FailListServices = false
+	_, err = r.ListServices()
+	assert.Nil(t, err)
+	assert.False(t, r.LastDiscovery().IsZero())
+}
+
 func TestDescribeService(t *testing.T) {
 	r, api := setUp(t)
 
@@ -139,3 +183,608 @@ func TestDescribeServiceShouldReturnErrorOnInvalidPort(t *testing.T) {
 	_, err := r.DescribeService("service1")
 	assert.NotNil(t, err)
 }
+
+func setUpDeployments(api *interfaces.AwsEcsAPIMock, primaryRunning int64) {
+	api.Services["service1"] = &ecs.Service{
+		TaskDefinition: aws.String("taskDef2"),
+		Deployments: []*ecs.Deployment{
+			&ecs.Deployment{
+				Id:             aws.String("deployment2"),
+				Status:         aws.String(DeploymentStatusPrimary),
+				TaskDefinition: aws.String("taskDef2"),
+				DesiredCount:   aws.Int64(2),
+				RunningCount:   aws.Int64(primaryRunning),
+			},
+			&ecs.Deployment{
+				Id:             aws.String("deployment1"),
+				Status:         aws.String("ACTIVE"),
+				TaskDefinition: aws.String("taskDef1"),
+				DesiredCount:   aws.Int64(0),
+				RunningCount:   aws.Int64(2),
+			},
+		},
+	}
+
+	for i, hostname := range []string{"v1", "v2"} {
+		api.TaskDefs[fmt.Sprintf("taskDef%d", i+1)] = &ecs.TaskDefinition{
+			Revision: aws.Int64(int64(i + 1)),
+			ContainerDefinitions: []*ecs.ContainerDefinition{
+				&ecs.ContainerDefinition{
+					Name:     aws.String(DefaultServerContainerName),
+					Hostname: aws.String(hostname),
+				},
+			},
+		}
+	}
+}
+
+func TestDescribeServiceDetailsResolvesAllDeployments(t *testing.T) {
+	r, api := setUp(t)
+	setUpDeployments(api, 1)
+
+	desc, err := r.DescribeServiceDetails("service1")
+	assert.Nil(t, err)
+
+	v2, _ := url.Parse("http://v2:8080")
+	v1, _ := url.Parse("http://v1:8080")
+
+	assert.EqualValues(t, &ServiceDescription{
+		Name: "service1",
+		ServerSets: []*ServerSet{&ServerSet{
+			Service: "service1",
+			Weight:  TotalWeight,
+			Servers: []*Server{
+				&Server{
+					URL:              v2,
+					Transport:        Transport{Protocol: ProtocolHTTP1},
+					Container:        DefaultServerContainerName,
+					HealthCheck:      DefaultHealthCheck,
+					DeploymentID:     "deployment2",
+					DeploymentStatus: DeploymentStatusPrimary,
+					TaskDefinition:   "taskDef2",
+					Revision:         2,
+				},
+				&Server{
+					URL:              v1,
+					Transport:        Transport{Protocol: ProtocolHTTP1},
+					Container:        DefaultServerContainerName,
+					HealthCheck:      DefaultHealthCheck,
+					DeploymentID:     "deployment1",
+					DeploymentStatus: "ACTIVE",
+					TaskDefinition:   "taskDef1",
+					Revision:         1,
+				},
+			},
+		}},
+	}, desc)
+}
+
+func TestDescribeServiceDetailsSkipsInactiveAndDrainedDeployments(t *testing.T) {
+	r, api := setUp(t)
+	setUpDeployments(api, 1)
+
+	deployments := api.Services["service1"].Deployments
+	deployments[1].Status = aws.String("INACTIVE")
+
+	desc, err := r.DescribeServiceDetails("service1")
+	assert.Nil(t, err)
+	assert.Len(t, desc.Servers(), 1)
+	assert.Equal(t, "deployment2", desc.Servers()[0].DeploymentID)
+
+	deployments[1].Status = aws.String(DeploymentStatusActive)
+	deployments[1].RunningCount = aws.Int64(0)
+
+	desc, err = r.DescribeServiceDetails("service1")
+	assert.Nil(t, err)
+	assert.Len(t, desc.Servers(), 1)
+	assert.Equal(t, "deployment2", desc.Servers()[0].DeploymentID)
+
+	// without running tasks the primary deployment keeps the service routed
+	deployments[0].RunningCount = aws.Int64(0)
+
+	desc, err = r.DescribeServiceDetails("service1")
+	assert.Nil(t, err)
+	assert.Len(t, desc.Servers(), 1)
+	assert.Equal(t, "deployment2", desc.Servers()[0].DeploymentID)
+}
+
+func TestDescribeServiceDetailsRoutesSharedHostNamesOnce(t *testing.T) {
+	r, api := setUp(t)
+	setUpDeployments(api, 1)
+
+	api.TaskDefs["taskDef1"].ContainerDefinitions[0].Hostname = aws.String("v2")
+
+	desc, err := r.DescribeServiceDetails("service1")
+	assert.Nil(t, err)
+	assert.Len(t, desc.Servers(), 1)
+	assert.Equal(t, "deployment2", desc.Servers()[0].DeploymentID)
+}
+
+func TestDescribeServiceDetailsPrimaryWhenReady(t *testing.T) {
+	r, api := setUp(t, WithDeploymentPolicy(DeploymentPolicyPrimaryWhenReady))
+
+	// primary not yet at its desired count: all deployments
+	setUpDeployments(api, 1)
+
+	desc, err := r.DescribeServiceDetails("service1")
+	assert.Nil(t, err)
+	assert.Len(t, desc.Servers(), 2)
+
+	// primary ready: only the primary deployment
+	setUpDeployments(api, 2)
+
+	desc, err = r.DescribeServiceDetails("service1")
+	assert.Nil(t, err)
+	assert.Len(t, desc.Servers(), 1)
+	assert.Equal(t, "deployment2", desc.Servers()[0].DeploymentID)
+}
+
+func TestNewServiceRepositoryWithInvalidDeploymentPolicy(t *testing.T) {
+	api := interfaces.NewAwsEcsAPIMock()
+
+	_, err := NewServiceRepository(api, WithDeploymentPolicy(DeploymentPolicy(42)))
+	assert.NotNil(t, err)
+}
+
+func TestParseDeploymentPolicy(t *testing.T) {
+	policy, err := ParseDeploymentPolicy("all")
+	assert.Nil(t, err)
+	assert.Equal(t, DeploymentPolicyAll, policy)
+
+	policy, err = ParseDeploymentPolicy("primary-when-ready")
+	assert.Nil(t, err)
+	assert.Equal(t, DeploymentPolicyPrimaryWhenReady, policy)
+
+	_, err = ParseDeploymentPolicy("abc")
+	assert.NotNil(t, err)
+}
+
+func addService(api *interfaces.AwsEcsAPIMock, name, hostname string, labels map[string]string) {
+	api.ServiceNames = append(api.ServiceNames, name)
+
+	api.Services[name] = &ecs.Service{
+		ServiceName:    aws.String(name),
+		TaskDefinition: aws.String(name + "TaskDef"),
+	}
+
+	api.TaskDefs[name+"TaskDef"] = &ecs.TaskDefinition{
+		ContainerDefinitions: []*ecs.ContainerDefinition{
+			&ecs.ContainerDefinition{
+				DockerLabels: aws.StringMap(labels),
+				Name:         aws.String(DefaultServerContainerName),
+				Hostname:     aws.String(hostname),
+			},
+		},
+	}
+}
+
+func TestDescribeServiceDetailsHosts(t *testing.T) {
+	r, api := setUp(t, WithNamedPorts(true))
+
+	addService(api, "web", "web", map[string]string{
+		DefaultDockerLabelHosts:            "www.example.com, Example.com,,www.example.com",
+		DefaultDockerLabelPorts + "admin":  "9090",
+		DefaultDockerLabelHosts + ".admin": "admin.example.com",
+	})
+
+	desc, err := r.DescribeServiceDetails("web")
+	assert.Nil(t, err)
+	assert.EqualValues(t, []string{"www.example.com", "example.com"}, desc.Hosts)
+
+	desc, err = r.DescribeServiceDetails("web" + PortNameSeparator + "admin")
+	assert.Nil(t, err)
+	assert.EqualValues(t, []string{"admin.example.com"}, desc.Hosts)
+}
+
+func TestListServicesWithCanaryGrouping(t *testing.T) {
+	r, api := setUp(t, WithCanaryGrouping(true))
+
+	addService(api, "service1", "primary", nil)
+	addService(api, "service1-canary", "canary", map[string]string{
+		DefaultDockerLabelCanaryOf: "service1",
+		DefaultDockerLabelWeight:   "10",
+	})
+	addService(api, "service2", "other", nil)
+
+	names, err := r.ListServices()
+	assert.Nil(t, err)
+	assert.EqualValues(t, []string{"service1", "service2"}, names)
+}
+
+func TestDescribeServiceDetailsWithCanaryGrouping(t *testing.T) {
+	r, api := setUp(t, WithCanaryGrouping(true))
+
+	addService(api, "service1", "primary", nil)
+	addService(api, "service1-canary", "canary", map[string]string{
+		DefaultDockerLabelCanaryOf: "service1",
+		DefaultDockerLabelWeight:   "10",
+	})
+	addService(api, "service2", "other", nil)
+
+	desc, err := r.DescribeServiceDetails("service1")
+	assert.Nil(t, err)
+
+	if assert.Len(t, desc.ServerSets, 2) {
+		assert.Equal(t, "service1", desc.ServerSets[0].Service)
+		assert.Equal(t, 90, desc.ServerSets[0].Weight)
+		assert.Equal(t, "service1-canary", desc.ServerSets[1].Service)
+		assert.Equal(t, 10, desc.ServerSets[1].Weight)
+	}
+
+	svc, err := r.DescribeService("service1")
+	assert.Nil(t, err)
+	assert.Len(t, svc.Servers, 10)
+}
+
+func TestDescribeServiceDetailsShouldUseCanariesOfLastListing(t *testing.T) {
+	r, api := setUp(t, WithCanaryGrouping(true))
+
+	for i := 0; i < 50; i++ {
+		name := fmt.Sprintf("service%02d", i)
+		addService(api, name, name, nil)
+	}
+
+	addService(api, "service00-canary", "canary", map[string]string{
+		DefaultDockerLabelCanaryOf: "service00",
+		DefaultDockerLabelWeight:   "10",
+	})
+
+	// without a listing, the canaries are indexed by the first description
+	desc, err := r.DescribeServiceDetails("service00")
+	assert.Nil(t, err)
+	assert.Len(t, desc.ServerSets, 2)
+
+	names, err := r.ListServices()
+	assert.Nil(t, err)
+	assert.Len(t, names, 50)
+
+	for _, name := range names {
+		_, err := r.DescribeServiceDetails(name)
+		assert.Nil(t, err)
+	}
+
+	assert.Equal(t, 2, api.ListServicesCalls)
+	assert.Equal(t, (51+1)+51+50, api.DescribeServiceCalls)
+
+	// a new canary is found by the next listing
+	addService(api, "service01-canary", "canary", map[string]string{
+		DefaultDockerLabelCanaryOf: "service01",
+		DefaultDockerLabelWeight:   "10",
+	})
+
+	desc, err = r.DescribeServiceDetails("service01")
+	assert.Nil(t, err)
+	assert.Len(t, desc.ServerSets, 1)
+
+	_, err = r.ListServices()
+	assert.Nil(t, err)
+
+	desc, err = r.DescribeServiceDetails("service01")
+	assert.Nil(t, err)
+	assert.Len(t, desc.ServerSets, 2)
+}
+
+func TestListServicesWithCanaryGroupingShouldFailOnAPIErrors(t *testing.T) {
+	r, api := setUp(t, WithCanaryGrouping(true), WithWorkers(4))
+
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("service%02d", i)
+		addService(api, name, name, nil)
+	}
+
+	api.DescribeTaskDefinitionFailures = 1
+
+	_, err := r.ListServices()
+	assert.NotNil(t, err)
+
+	// other problems are reported when the service is described
+	api.TaskDefs["service03TaskDef"].ContainerDefinitions = nil
+
+	names, err := r.ListServices()
+	assert.Nil(t, err)
+	assert.Len(t, names, 10)
+
+	_, err = r.DescribeServiceDetails("service03")
+	assert.NotNil(t, err)
+}
+
+func TestDescribeServiceDetailsShouldLeaveOutMisconfiguredCanaries(t *testing.T) {
+	var reported []string
+
+	handler := func(name string, err error) {
+		reported = append(reported, name+": "+err.Error())
+	}
+
+	r, api := setUp(t, WithCanaryGrouping(true), WithCanaryErrorHandler(handler))
+
+	addService(api, "service1", "primary", map[string]string{
+		DefaultDockerLabelWeight: "85",
+	})
+	addService(api, "service1-a", "a", map[string]string{
+		DefaultDockerLabelCanaryOf: "service1",
+		DefaultDockerLabelWeight:   "10",
+	})
+	addService(api, "service1-b", "b", map[string]string{
+		DefaultDockerLabelCanaryOf: "service1",
+		DefaultDockerLabelWeight:   "10",
+	})
+	addService(api, "service1-c", "c", map[string]string{
+		DefaultDockerLabelCanaryOf: "service1",
+		DefaultDockerLabelWeight:   "abc",
+	})
+	// a canary without weight would silently get no traffic
+	addService(api, "service1-d", "d", map[string]string{
+		DefaultDockerLabelCanaryOf: "service1",
+	})
+	addService(api, "service1-e", "e", map[string]string{
+		DefaultDockerLabelCanaryOf: "service1",
+		DefaultDockerLabelWeight:   "5",
+		DefaultDockerLabelPort:     "abc",
+	})
+
+	desc, err := r.DescribeServiceDetails("service1")
+	assert.Nil(t, err)
+
+	if assert.Len(t, desc.ServerSets, 2) {
+		assert.Equal(t, 85, desc.ServerSets[0].Weight)
+		assert.Equal(t, "service1-a", desc.ServerSets[1].Service)
+		assert.Equal(t, 10, desc.ServerSets[1].Weight)
+	}
+
+	assert.EqualValues(t, []string{
+		"service1: canary service1-b of service service1: weight 10 exceeds the remaining weight 5 of 100",
+		"service1: canary service1-c of service service1: invalid weight: abc",
+		"service1: canary service1-d of service service1: no weight",
+		"service1: canary service1-e of service service1: invalid port: abc",
+	}, reported)
+
+	// the problems are reported by validation as well
+	problems := r.ValidateService("service1")
+	assert.Len(t, problems, 4)
+}
+
+func TestDescribeServiceDetailsShouldReturnErrorOnInvalidPrimaryWeight(t *testing.T) {
+	r, api := setUp(t, WithCanaryGrouping(true))
+
+	addService(api, "service1", "primary", map[string]string{
+		DefaultDockerLabelWeight: "abc",
+	})
+
+	_, err := r.DescribeServiceDetails("service1")
+	assert.NotNil(t, err)
+}
+
+func TestDescribeServiceDetailsWithTransportLabels(t *testing.T) {
+	r, api := setUp(t)
+
+	addService(api, "service1", "hostname", map[string]string{
+		DefaultDockerLabelScheme:   "https",
+		DefaultDockerLabelProtocol: "grpc",
+		DefaultDockerLabelTLSName:  "service1.internal",
+		DefaultDockerLabelTLSSkip:  "true",
+	})
+
+	desc, err := r.DescribeServiceDetails("service1")
+	assert.Nil(t, err)
+
+	server := desc.Servers()[0]
+	assert.Equal(t, "https://hostname:8080", server.URL.String())
+	assert.Equal(t, Transport{
+		Protocol:      ProtocolGRPC,
+		TLSServerName: "service1.internal",
+		TLSSkipVerify: true,
+	}, server.Transport)
+}
+
+func TestDescribeServiceDetailsShouldReturnErrorOnInvalidTransportLabels(t *testing.T) {
+	for _, labels := range []map[string]string{
+		{DefaultDockerLabelScheme: "ftp"},
+		{DefaultDockerLabelProtocol: "spdy"},
+		{DefaultDockerLabelScheme: "https", DefaultDockerLabelProtocol: "h2c"},
+		{DefaultDockerLabelScheme: "https", DefaultDockerLabelTLSSkip: "maybe"},
+		{DefaultDockerLabelTLSName: "service1.internal"},
+	} {
+		r, api := setUp(t)
+		addService(api, "service1", "hostname", labels)
+
+		_, err := r.DescribeServiceDetails("service1")
+		assert.NotNil(t, err, "labels: %v", labels)
+	}
+}
+
+func TestListServicesWithNamedPorts(t *testing.T) {
+	r, api := setUp(t, WithNamedPorts(true))
+
+	addService(api, "service1", "hostname", map[string]string{
+		DefaultDockerLabelPorts + "api":   "8080",
+		DefaultDockerLabelPorts + "admin": "9000",
+	})
+	addService(api, "service2", "other", nil)
+
+	names, err := r.ListServices()
+	assert.Nil(t, err)
+	assert.EqualValues(t, []string{"service1#admin", "service1#api", "service2"}, names)
+}
+
+func TestDescribeServiceDetailsWithNamedPort(t *testing.T) {
+	r, api := setUp(t, WithNamedPorts(true))
+
+	addService(api, "service1", "hostname", map[string]string{
+		DefaultDockerLabelPorts + "api":   "8080",
+		DefaultDockerLabelPorts + "admin": "9000",
+	})
+
+	desc, err := r.DescribeServiceDetails("service1#admin")
+	assert.Nil(t, err)
+	assert.Equal(t, "service1#admin", desc.Name)
+	assert.Equal(t, "service1", desc.ServerSets[0].Service)
+	assert.Equal(t, "http://hostname:9000", desc.Servers()[0].URL.String())
+
+	_, err = r.DescribeServiceDetails("service1#unknown")
+	assert.NotNil(t, err)
+}
+
+func TestNewServiceRepositoryWithInvalidServerContainerName(t *testing.T) {
+	api := interfaces.NewAwsEcsAPIMock()
+
+	_, err := NewServiceRepository(api, WithServerContainerNames("["))
+	assert.NotNil(t, err)
+}
+
+func TestDescribeServiceDetailsWithMultipleServerContainers(t *testing.T) {
+	r, api := setUp(t, WithServerContainerNames("web-*"))
+
+	api.Services["service1"] = &ecs.Service{TaskDefinition: aws.String("taskDef1")}
+	api.TaskDefs["taskDef1"] = &ecs.TaskDefinition{
+		ContainerDefinitions: []*ecs.ContainerDefinition{
+			&ecs.ContainerDefinition{
+				Name:     aws.String("web-1"),
+				Hostname: aws.String("web1"),
+			},
+			&ecs.ContainerDefinition{
+				Name:     aws.String("sidecar"),
+				Hostname: aws.String("sidecar"),
+			},
+			&ecs.ContainerDefinition{
+				DockerLabels: aws.StringMap(map[string]string{
+					DefaultDockerLabelExpose: "false",
+				}),
+				Name:     aws.String("web-debug"),
+				Hostname: aws.String("debug"),
+			},
+			&ecs.ContainerDefinition{
+				DockerLabels: aws.StringMap(map[string]string{
+					DefaultDockerLabelExpose: "true",
+					DefaultDockerLabelPort:   "9090",
+				}),
+				Name:     aws.String("api"),
+				Hostname: aws.String("api"),
+			},
+		},
+	}
+
+	desc, err := r.DescribeServiceDetails("service1")
+	assert.Nil(t, err)
+
+	var urls []string
+	for _, server := range desc.Servers() {
+		urls = append(urls, server.URL.String())
+	}
+
+	assert.EqualValues(t, []string{"http://web1:8080", "http://api:9090"}, urls)
+}
+
+func TestDescribeServiceShouldReturnErrorOnInvalidExposeLabel(t *testing.T) {
+	r, api := setUp(t)
+
+	addService(api, "service1", "hostname", map[string]string{
+		DefaultDockerLabelExpose: "abc",
+	})
+
+	_, err := r.DescribeServiceDetails("service1")
+	assert.NotNil(t, err)
+}
+
+func TestDescribeServiceDetailsWithHealthCheck(t *testing.T) {
+	r, api := setUp(t)
+
+	addService(api, "service1", "hostname", map[string]string{
+		DefaultDockerLabelHealth + HealthCheckLabelTimeout: "2s",
+		DefaultDockerLabelHealth + HealthCheckLabelStatus:  "204",
+	})
+
+	api.TaskDefs["service1TaskDef"].ContainerDefinitions[0].HealthCheck = &ecs.HealthCheck{
+		Command:  aws.StringSlice([]string{"CMD-SHELL", "curl -f http://localhost:8080/health || exit 1"}),
+		Interval: aws.Int64(10),
+		Timeout:  aws.Int64(3),
+		Retries:  aws.Int64(5),
+	}
+
+	desc, err := r.DescribeServiceDetails("service1")
+	assert.Nil(t, err)
+
+	assert.Equal(t, HealthCheck{
+		Path:               "/health",
+		Interval:           10 * time.Second,
+		Timeout:            2 * time.Second,
+		ExpectedStatus:     204,
+		UnhealthyThreshold: 5,
+	}, desc.Servers()[0].HealthCheck)
+}
+
+func TestDescribeServiceDetailsWithDefaultHealthCheck(t *testing.T) {
+	healthCheck := HealthCheck{
+		Path:               "/ping",
+		Interval:           10 * time.Second,
+		Timeout:            time.Second,
+		UnhealthyThreshold: 2,
+	}
+
+	r, api := setUp(t, WithDefaultHealthCheck(healthCheck))
+	addService(api, "service1", "hostname", nil)
+
+	desc, err := r.DescribeServiceDetails("service1")
+	assert.Nil(t, err)
+
+	assert.Equal(t, healthCheck, desc.Servers()[0].HealthCheck)
+}
+
+func TestWithDefaultHealthCheckShouldRejectInvalidHealthCheck(t *testing.T) {
+	invalid := DefaultHealthCheck
+	invalid.Interval = 0
+
+	_, err := NewServiceRepository(nil, WithDefaultHealthCheck(invalid))
+	assert.NotNil(t, err)
+}
+
+func TestDescribeServiceDetailsShouldReturnErrorOnInvalidHealthCheckLabels(t *testing.T) {
+	for _, labels := range []map[string]string{
+		{DefaultDockerLabelHealth + HealthCheckLabelPath: "health"},
+		{DefaultDockerLabelHealth + HealthCheckLabelInterval: "soon"},
+		{DefaultDockerLabelHealth + HealthCheckLabelTimeout: "-1s"},
+		{DefaultDockerLabelHealth + HealthCheckLabelStatus: "42"},
+		{DefaultDockerLabelHealth + HealthCheckLabelUnhealthyThreshold: "0"},
+		{DefaultDockerLabelHealth + "unknown": "value"},
+	} {
+		r, api := setUp(t)
+		addService(api, "service1", "hostname", labels)
+
+		_, err := r.DescribeServiceDetails("service1")
+		assert.NotNil(t, err, "labels: %v", labels)
+	}
+}
+
+type urlFilter string
+
+func (f urlFilter) FilterServers(servers []*Server) []*Server {
+	var filtered []*Server
+
+	for _, server := range servers {
+		if server.URL.String() != string(f) {
+			filtered = append(filtered, server)
+		}
+	}
+
+	return filtered
+}
+
+func TestDescribeServiceDetailsWithServerFilters(t *testing.T) {
+	r, api := setUp(t, WithServerFilters(urlFilter("http://v1:8080")))
+	setUpDeployments(api, 1)
+
+	desc, err := r.DescribeServiceDetails("service1")
+	assert.Nil(t, err)
+
+	if assert.Len(t, desc.Servers(), 1) {
+		assert.Equal(t, "http://v2:8080", desc.Servers()[0].URL.String())
+	}
+
+	// a filter leaving no servers is ignored
+	r, api = setUp(t, WithServerFilters(urlFilter("http://v2:8080")))
+	setUpDeployments(api, 2)
+	api.Services["service1"].Deployments = api.Services["service1"].Deployments[:1]
+
+	desc, err = r.DescribeServiceDetails("service1")
+	assert.Nil(t, err)
+	assert.Len(t, desc.Servers(), 1)
+}
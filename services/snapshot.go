@@ -0,0 +1,188 @@
+// Copyright (c) 2017 off-sync
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package services
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/off-sync/platform-proxy-aws/interfaces"
+	"github.com/off-sync/platform-proxy-domain/services"
+)
+
+// ServiceState is the last known state of a service.
+type ServiceState struct {
+	Name string `json:"name"`
+
+	// Description is the last successful description of the service.
+	Description *ServiceDescription `json:"description,omitempty"`
+
+	// Refreshed is the time of the last successful description.
+	Refreshed time.Time `json:"refreshed"`
+
+	// Error is set if the last attempt to describe the service failed.
+	Error string `json:"error,omitempty"`
+}
+
+// Snapshot is the last known state of all services of a repository.
+type Snapshot struct {
+	// Discovered is the time services were last listed successfully.
+	Discovered time.Time `json:"discovered"`
+
+	// Names are the names of the services as last listed.
+	Names []string `json:"names"`
+
+	// Services are the states of the services, ordered by name.
+	Services []*ServiceState `json:"services"`
+}
+
+// Snapshot returns the last known state of the services, as discovered by
+// ListServices and described by DescribeService and DescribeServiceDetails.
+func (r *ServiceRepository) Snapshot() *Snapshot {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	discovered := r.lastDiscovery
+	if r.restored && discovered.IsZero() {
+		discovered = r.restoredDiscovery
+	}
+
+	snapshot := &Snapshot{
+		Discovered: discovered,
+		Names:      append([]string{}, r.lastNames...),
+		Services:   make([]*ServiceState, 0, len(r.states)),
+	}
+
+	for _, state := range r.states {
+		copied := *state
+		snapshot.Services = append(snapshot.Services, &copied)
+	}
+
+	sort.Slice(snapshot.Services, func(i, j int) bool {
+		return snapshot.Services[i].Name < snapshot.Services[j].Name
+	})
+
+	return snapshot
+}
+
+// Refresh lists and describes all services, updating their state. Errors
+// describing a single service are recorded in its state; only an error
+// listing the services is returned.
+//
+// Refreshing never falls back to stale data. After a successful refresh, a
+// restored snapshot is no longer served.
+func (r *ServiceRepository) Refresh() error {
+	return r.RefreshWithContext(context.Background())
+}
+
+// RefreshWithContext is Refresh using the provided context. If the context is
+// cancelled, the refresh stops and the context error is returned.
+func (r *ServiceRepository) RefreshWithContext(ctx context.Context) error {
+	names, err := r.listServicesAndRecord(ctx)
+	if err != nil {
+		return unmarkAPIError(err)
+	}
+
+	r.describeConcurrently(ctx, names, r.describeServiceDetailsAndRecord)
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	r.restored = false
+	r.mutex.Unlock()
+
+	return nil
+}
+
+// RefreshService describes a single service, updating its state.
+func (r *ServiceRepository) RefreshService(name string) (*ServiceState, error) {
+	return r.RefreshServiceWithContext(context.Background(), name)
+}
+
+// RefreshServiceWithContext is RefreshService using the provided context.
+func (r *ServiceRepository) RefreshServiceWithContext(ctx context.Context, name string) (*ServiceState, error) {
+	_, err := r.describeServiceDetailsAndRecord(ctx, name)
+	err = unmarkAPIError(err)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	state, found := r.states[name]
+	if !found {
+		return nil, err
+	}
+
+	copied := *state
+
+	return &copied, err
+}
+
+// recordDiscovery records a successful listing of the services. The states of
+// services that are no longer listed are removed.
+func (r *ServiceRepository) recordDiscovery(names []string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.lastDiscovery = time.Now()
+	r.lastNames = names
+
+	listed := make(map[string]bool, len(names))
+	for _, name := range names {
+		listed[name] = true
+	}
+
+	for name := range r.states {
+		if !listed[name] {
+			delete(r.states, name)
+		}
+	}
+}
+
+// recordDescription records the result of describing a service. The state of
+// a service that no longer exists is removed. On other errors the last
+// successful description is kept.
+func (r *ServiceRepository) recordDescription(name string, desc *ServiceDescription, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if err == interfaces.ErrServiceNotFound || err == services.ErrUnknownService {
+		delete(r.states, name)
+		return
+	}
+
+	state, found := r.states[name]
+	if !found {
+		state = &ServiceState{Name: name}
+		r.states[name] = state
+	}
+
+	if err != nil {
+		state.Error = err.Error()
+		return
+	}
+
+	state.Description = desc
+	state.Refreshed = time.Now()
+	state.Error = ""
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefreshRecordsServiceStates(t *testing.T) {
+	r, api := setUp(t)
+
+	addService(api, "web", "web", nil)
+	addService(api, "api", "api", map[string]string{
+		DefaultDockerLabelPort: "abc",
+	})
+
+	assert.Nil(t, r.Refresh())
+
+	snapshot := r.Snapshot()
+	assert.False(t, snapshot.Discovered.IsZero())
+
+	if assert.Len(t, snapshot.Services, 2) {
+		assert.Equal(t, "api", snapshot.Services[0].Name)
+		assert.Nil(t, snapshot.Services[0].Description)
+		assert.Equal(t, "invalid port: abc", snapshot.Services[0].Error)
+
+		assert.Equal(t, "web", snapshot.Services[1].Name)
+		assert.Equal(t, "web", snapshot.Services[1].Description.Name)
+		assert.False(t, snapshot.Services[1].Refreshed.IsZero())
+		assert.Equal(t, "", snapshot.Services[1].Error)
+	}
+}
+
+func TestRefreshServiceKeepsLastDescriptionOnError(t *testing.T) {
+	r, api := setUp(t)
+
+	addService(api, "web", "web", nil)
+
+	state, err := r.RefreshService("web")
+	assert.Nil(t, err)
+	assert.NotNil(t, state.Description)
+
+	api.FailDescribeService = true
+
+	state, err = r.RefreshService("web")
+	assert.NotNil(t, err)
+	assert.NotNil(t, state.Description)
+	assert.NotEqual(t, "", state.Error)
+}
+
+func TestRefreshRemovesDeletedServices(t *testing.T) {
+	r, api := setUp(t)
+
+	addService(api, "web", "web", nil)
+	addService(api, "admin", "admin", nil)
+
+	assert.Nil(t, r.Refresh())
+	assert.Len(t, r.Snapshot().Services, 2)
+
+	// a service that is no longer listed is removed
+	api.ServiceNames = []string{"web"}
+
+	assert.Nil(t, r.Refresh())
+	assert.Len(t, r.Snapshot().Services, 1)
+
+	// as is a service that can no longer be found
+	delete(api.Services, "web")
+
+	state, err := r.RefreshService("web")
+	assert.NotNil(t, err)
+	assert.Nil(t, state)
+	assert.Len(t, r.Snapshot().Services, 0)
+}
+
+func TestRefreshShouldReturnErrorWhenListingFails(t *testing.T) {
+	r, api := setUp(t)
+	api.FailListServices = true
+
+	assert.NotNil(t, r.Refresh())
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2017 off-sync
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package services
+
+import (
+	"time"
+)
+
+// StaleHandler is called when a service repository serves stale data because
+// the AWS ECS API failed. The name is empty if the services could not be
+// listed. The age is the time since the data was last refreshed.
+type StaleHandler func(name string, age time.Duration, err error)
+
+// WithMaxStaleness configures a service repository to serve the last known
+// good services and descriptions when the AWS ECS API fails, as long as they
+// are not older than the provided duration. Zero disables serving stale data.
+func WithMaxStaleness(d time.Duration) ServiceRepositoryOption {
+	return func(r *ServiceRepository) error {
+		r.maxStaleness = d
+		return nil
+	}
+}
+
+// WithStaleHandler configures a service repository with the provided handler,
+// which is called whenever stale data is served.
+func WithStaleHandler(h StaleHandler) ServiceRepositoryOption {
+	return func(r *ServiceRepository) error {
+		r.staleHandler = h
+		return nil
+	}
+}
+
+// staleServices returns the services of the last successful listing, if it is
+// recent enough.
+func (r *ServiceRepository) staleServices(err error) ([]string, bool) {
+	r.mutex.Lock()
+
+	if r.lastDiscovery.IsZero() {
+		r.mutex.Unlock()
+		return nil, false
+	}
+
+	age, ok := r.withinMaxStaleness(r.lastDiscovery)
+	if !ok {
+		r.mutex.Unlock()
+		return nil, false
+	}
+
+	names := make([]string, len(r.lastNames))
+	copy(names, r.lastNames)
+
+	r.mutex.Unlock()
+
+	r.handleStale("", age, err)
+
+	return names, true
+}
+
+// staleDescription returns the last successful description of the service,
+// marked as stale, if it is recent enough.
+func (r *ServiceRepository) staleDescription(name string, err error) *ServiceDescription {
+	r.mutex.Lock()
+
+	state, found := r.states[name]
+	if !found || state.Description == nil {
+		r.mutex.Unlock()
+		return nil
+	}
+
+	age, ok := r.withinMaxStaleness(state.Refreshed)
+	if !ok {
+		r.mutex.Unlock()
+		return nil
+	}
+
+	desc := *state.Description
+	desc.Stale = true
+
+	r.mutex.Unlock()
+
+	r.handleStale(name, age, err)
+
+	return &desc
+}
+
+// withinMaxStaleness returns the age of data refreshed at the provided time
+// and whether it may still be served.
+func (r *ServiceRepository) withinMaxStaleness(refreshed time.Time) (time.Duration, bool) {
+	if r.maxStaleness <= 0 {
+		return 0, false
+	}
+
+	age := time.Since(refreshed)
+
+	return age, age <= r.maxStaleness
+}
+
+// handleStale calls the stale handler, if any. It must be called without
+// holding the mutex.
+func (r *ServiceRepository) handleStale(name string, age time.Duration, err error) {
+	if r.staleHandler != nil {
+		r.staleHandler(name, age, unmarkAPIError(err))
+	}
+}
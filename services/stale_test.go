@@ -0,0 +1,153 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/off-sync/platform-proxy-aws/interfaces"
+)
+
+type staleCall struct {
+	name string
+	err  error
+}
+
+func setUpStale(t *testing.T, maxStaleness time.Duration) (*ServiceRepository, *interfaces.AwsEcsAPIMock, *[]staleCall) {
+	calls := &[]staleCall{}
+
+	r, api := setUp(t,
+		WithMaxStaleness(maxStaleness),
+		WithStaleHandler(func(name string, age time.Duration, err error) {
+			*calls = append(*calls, staleCall{name: name, err: err})
+		}))
+
+	addService(api, "web", "web", nil)
+
+	return r, api, calls
+}
+
+func TestListServicesShouldServeStaleServicesWhenAPIFails(t *testing.T) {
+	r, api, calls := setUpStale(t, time.Minute)
+
+	names, err := r.ListServices()
+	assert.Nil(t, err)
+
+	api.FailListServices = true
+
+	stale, err := r.ListServices()
+	assert.Nil(t, err)
+	assert.Equal(t, names, stale)
+
+	if assert.Len(t, *calls, 1) {
+		assert.Equal(t, "", (*calls)[0].name)
+		assert.NotNil(t, (*calls)[0].err)
+	}
+}
+
+func TestListServicesShouldFailWithoutPreviousListing(t *testing.T) {
+	r, api, calls := setUpStale(t, time.Minute)
+
+	api.FailListServices = true
+
+	_, err := r.ListServices()
+	assert.NotNil(t, err)
+	assert.Len(t, *calls, 0)
+}
+
+func TestDescribeServiceDetailsShouldServeStaleDescriptionWhenAPIFails(t *testing.T) {
+	r, api, calls := setUpStale(t, time.Minute)
+
+	desc, err := r.DescribeServiceDetails("web")
+	assert.Nil(t, err)
+	assert.False(t, desc.Stale)
+
+	api.FailDescribeService = true
+
+	stale, err := r.DescribeServiceDetails("web")
+	assert.Nil(t, err)
+	assert.True(t, stale.Stale)
+	assert.Equal(t, desc.ServerSets, stale.ServerSets)
+
+	service, err := r.DescribeService("web")
+	assert.Nil(t, err)
+	assert.Equal(t, desc.URLs(), service.Servers)
+
+	if assert.Len(t, *calls, 2) {
+		assert.Equal(t, "web", (*calls)[0].name)
+	}
+
+	// the recorded description itself is not marked as stale
+	assert.False(t, r.Snapshot().Services[0].Description.Stale)
+}
+
+func TestDescribeServiceDetailsShouldNotServeStaleDescriptionWhenDisabled(t *testing.T) {
+	r, api, calls := setUpStale(t, 0)
+
+	_, err := r.DescribeServiceDetails("web")
+	assert.Nil(t, err)
+
+	api.FailDescribeService = true
+
+	_, err = r.DescribeServiceDetails("web")
+	assert.NotNil(t, err)
+	assert.Len(t, *calls, 0)
+}
+
+func TestDescribeServiceDetailsShouldNotServeTooStaleDescription(t *testing.T) {
+	r, api, calls := setUpStale(t, time.Nanosecond)
+
+	_, err := r.DescribeServiceDetails("web")
+	assert.Nil(t, err)
+
+	time.Sleep(time.Millisecond)
+
+	api.FailDescribeService = true
+
+	_, err = r.DescribeServiceDetails("web")
+	assert.NotNil(t, err)
+	assert.Len(t, *calls, 0)
+}
+
+func TestDescribeServiceDetailsShouldNotServeStaleDescriptionOfDeletedService(t *testing.T) {
+	r, api, calls := setUpStale(t, time.Minute)
+
+	_, err := r.DescribeServiceDetails("web")
+	assert.Nil(t, err)
+
+	delete(api.Services, "web")
+
+	_, err = r.DescribeServiceDetails("web")
+	assert.Equal(t, interfaces.ErrServiceNotFound, err)
+	assert.Len(t, *calls, 0)
+}
+
+func TestDescribeServiceDetailsShouldNotServeStaleDescriptionWhenCancelled(t *testing.T) {
+	r, _, calls := setUpStale(t, time.Minute)
+
+	_, err := r.DescribeServiceDetails("web")
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = r.DescribeServiceDetailsWithContext(ctx, "web")
+	assert.Equal(t, context.Canceled, err)
+	assert.Len(t, *calls, 0)
+
+	// the last description is kept
+	assert.Equal(t, "", r.Snapshot().Services[0].Error)
+}
+
+func TestRefreshShouldNotServeStaleData(t *testing.T) {
+	r, api, calls := setUpStale(t, time.Minute)
+
+	assert.Nil(t, r.Refresh())
+
+	api.FailListServices = true
+
+	assert.NotNil(t, r.Refresh())
+	assert.Len(t, *calls, 0)
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 2017 off-sync
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package services
+
+import (
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName is the name of the tracer used by the service repository.
+const TracerName = "github.com/off-sync/platform-proxy-aws/services"
+
+// Span attributes.
+const (
+	// AttributeCluster is the name of the ECS cluster. It is expected to be
+	// set on the resource of the tracer provider.
+	AttributeCluster = attribute.Key("aws.ecs.cluster.name")
+
+	AttributeService        = attribute.Key("platform_proxy.service")
+	AttributeServiceARN     = attribute.Key("aws.ecs.service.arn")
+	AttributeTaskDefinition = attribute.Key("aws.ecs.task_definition.arn")
+	AttributeContainer      = attribute.Key("aws.ecs.container.name")
+)
+
+// WithTracerProvider configures a service repository to create its spans
+// using the provided tracer provider instead of the global one.
+func WithTracerProvider(provider trace.TracerProvider) ServiceRepositoryOption {
+	return func(r *ServiceRepository) error {
+		r.tracer = provider.Tracer(TracerName)
+		return nil
+	}
+}
+
+// endSpan records the error, if any, on the span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
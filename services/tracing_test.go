@@ -0,0 +1,68 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func setUpTracing(t *testing.T) (*ServiceRepository, *tracetest.SpanRecorder) {
+	recorder := tracetest.NewSpanRecorder()
+
+	r, api := setUp(t, WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))))
+
+	addService(api, "web", "web", nil)
+
+	return r, recorder
+}
+
+func TestDescribeServiceSpans(t *testing.T) {
+	r, recorder := setUpTracing(t)
+
+	_, err := r.DescribeService("web")
+	assert.Nil(t, err)
+
+	spans := recorder.Ended()
+
+	var names []string
+	for _, span := range spans {
+		names = append(names, span.Name())
+	}
+
+	assert.EqualValues(t, []string{
+		"AwsEcsAPI.DescribeService",
+		"AwsEcsAPI.DescribeTaskDefinition",
+		"ServiceRepository.getTaskDefinitionServerURL",
+		"ServiceRepository.DescribeService",
+	}, names)
+
+	root := spans[len(spans)-1]
+	for _, span := range spans[:len(spans)-1] {
+		assert.Equal(t, root.SpanContext().TraceID(), span.SpanContext().TraceID())
+		assert.Equal(t, root.SpanContext().SpanID(), span.Parent().SpanID())
+	}
+
+	assert.Contains(t, root.Attributes(), AttributeService.String("web"))
+	assert.Contains(t, spans[0].Attributes(), AttributeServiceARN.String("web"))
+	assert.Contains(t, spans[0].Attributes(), AttributeTaskDefinition.String("webTaskDef"))
+	assert.Contains(t, spans[1].Attributes(), AttributeTaskDefinition.String("webTaskDef"))
+	assert.Contains(t, spans[2].Attributes(), AttributeTaskDefinition.String("webTaskDef"))
+	assert.Contains(t, spans[2].Attributes(), AttributeContainer.String(DefaultServerContainerName))
+}
+
+func TestDescribeServiceSpansRecordErrors(t *testing.T) {
+	r, recorder := setUpTracing(t)
+
+	_, err := r.DescribeService("unknown")
+	assert.NotNil(t, err)
+
+	spans := recorder.Ended()
+	if assert.Len(t, spans, 2) {
+		for _, span := range spans {
+			assert.Equal(t, codes.Error, span.Status().Code)
+		}
+	}
+}
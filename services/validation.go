@@ -0,0 +1,237 @@
+// Copyright (c) 2017 off-sync
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// ServiceValidation holds the problems found while validating an ECS service.
+type ServiceValidation struct {
+	Name     string
+	Problems []error
+}
+
+// Valid returns whether no problems were found.
+func (v *ServiceValidation) Valid() bool {
+	return len(v.Problems) < 1
+}
+
+// ValidateServices validates every ECS service of the cluster, including
+// canary services and services exposing named ports. It only returns an error
+// if the services cannot be listed.
+func (r *ServiceRepository) ValidateServices() ([]*ServiceValidation, error) {
+	return r.ValidateServicesWithContext(context.Background())
+}
+
+// ValidateServicesWithContext is ValidateServices using the provided context,
+// which is passed on to the AWS ECS API calls. If the context is cancelled,
+// validation stops and the context error is returned.
+func (r *ServiceRepository) ValidateServicesWithContext(ctx context.Context) ([]*ServiceValidation, error) {
+	names, err := r.listECSServices(ctx)
+	if err != nil {
+		return nil, unmarkAPIError(err)
+	}
+
+	validations := make([]*ServiceValidation, 0, len(names))
+
+	for _, name := range names {
+		problems := r.ValidateServiceWithContext(ctx, name)
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		validations = append(validations, &ServiceValidation{
+			Name:     name,
+			Problems: problems,
+		})
+	}
+
+	return validations, nil
+}
+
+// ValidateService validates the ECS service with the provided name and returns
+// all problems found. The task definitions of all deployments are validated,
+// regardless of the deployment policy.
+func (r *ServiceRepository) ValidateService(name string) []error {
+	return r.ValidateServiceWithContext(context.Background(), name)
+}
+
+// ValidateServiceWithContext is ValidateService using the provided context,
+// which is passed on to the AWS ECS API calls.
+func (r *ServiceRepository) ValidateServiceWithContext(ctx context.Context, name string) []error {
+	service, err := r.describeECSService(ctx, name)
+	if err != nil {
+		return []error{unmarkAPIError(err)}
+	}
+
+	var problems []error
+
+	validated := make(map[string]bool)
+
+	for _, deployment := range r.selectAllDeployments(service) {
+		taskDefArn := aws.StringValue(deployment.TaskDefinition)
+		if validated[taskDefArn] {
+			continue
+		}
+
+		validated[taskDefArn] = true
+
+		tdef, err := r.describeECSTaskDefinition(ctx, taskDefArn)
+		if err != nil {
+			problems = append(problems, unmarkAPIError(err))
+			continue
+		}
+
+		problems = append(problems, r.validateTaskDefinition(tdef)...)
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+
+	// resolve the service as a whole to find problems spanning services,
+	// such as the weights of canaries
+	names := []string{name}
+
+	labels, err := r.getServiceLabels(ctx, service)
+	if err != nil {
+		return []error{unmarkAPIError(err)}
+	}
+
+	for _, portName := range r.getPortNames(labels) {
+		names = append(names, name+PortNameSeparator+portName)
+	}
+
+	for _, logicalName := range names {
+		_, canaryErrs, err := r.resolveServiceDetails(ctx, logicalName)
+		if err != nil {
+			problems = append(problems, unmarkAPIError(err))
+		}
+
+		problems = append(problems, canaryErrs...)
+	}
+
+	return problems
+}
+
+// selectAllDeployments returns all deployments of the service.
+func (r *ServiceRepository) selectAllDeployments(service *ecs.Service) []*ecs.Deployment {
+	if len(service.Deployments) < 1 {
+		return r.selectDeployments(service)
+	}
+
+	return service.Deployments
+}
+
+// validateTaskDefinition returns all problems found in the server containers
+// of the task definition.
+func (r *ServiceRepository) validateTaskDefinition(tdef *ecs.TaskDefinition) []error {
+	var problems []error
+
+	serverContainers := 0
+
+	for _, cdef := range tdef.ContainerDefinitions {
+		isServer, err := r.isServerContainer(cdef)
+		if err != nil {
+			problems = append(problems, containerError(tdef, cdef, err))
+			continue
+		}
+
+		if !isServer {
+			continue
+		}
+
+		serverContainers++
+
+		for _, err := range r.validateServerContainer(cdef) {
+			problems = append(problems, containerError(tdef, cdef, err))
+		}
+	}
+
+	if serverContainers < 1 {
+		problems = append(problems, fmt.Errorf("no server container found for task definition: %s", aws.StringValue(tdef.TaskDefinitionArn)))
+	}
+
+	return problems
+}
+
+// validateServerContainer returns all problems found in the labels of the
+// server container.
+func (r *ServiceRepository) validateServerContainer(cdef *ecs.ContainerDefinition) []error {
+	var problems []error
+
+	scheme := DefaultScheme
+
+	for _, portName := range append([]string{""}, r.getPortNames(cdef.DockerLabels)...) {
+		serverURL, err := r.getContainerServerURL(cdef, portName)
+		if err != nil {
+			problems = appendProblem(problems, err)
+			continue
+		}
+
+		if u, err := url.Parse(serverURL); err == nil {
+			scheme = u.Scheme
+		}
+	}
+
+	if _, err := r.getServerTransport(scheme, cdef); err != nil {
+		problems = appendProblem(problems, err)
+	}
+
+	if _, err := r.getServerHealthCheck(cdef); err != nil {
+		problems = appendProblem(problems, err)
+	}
+
+	if label, found := cdef.DockerLabels[r.dockerLabelWeight]; found {
+		if _, err := parseWeight(aws.StringValue(label)); err != nil {
+			problems = appendProblem(problems, err)
+		}
+	}
+
+	return problems
+}
+
+// appendProblem appends the problem unless an identical problem was found
+// before, e.g. an invalid scheme reported for every port of a container.
+func appendProblem(problems []error, problem error) []error {
+	for _, p := range problems {
+		if p.Error() == problem.Error() {
+			return problems
+		}
+	}
+
+	return append(problems, problem)
+}
+
+func containerError(tdef *ecs.TaskDefinition, cdef *ecs.ContainerDefinition, err error) error {
+	if tdef.TaskDefinitionArn == nil {
+		return fmt.Errorf("container %s: %s", aws.StringValue(cdef.Name), err)
+	}
+
+	return fmt.Errorf("%s: container %s: %s", aws.StringValue(tdef.TaskDefinitionArn), aws.StringValue(cdef.Name), err)
+}
@@ -0,0 +1,88 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateServices(t *testing.T) {
+	r, api := setUp(t, WithCanaryGrouping(true))
+
+	addService(api, "valid", "hostname", map[string]string{
+		DefaultDockerLabelPorts + "admin": "9000",
+	})
+	addService(api, "invalid", "hostname", map[string]string{
+		DefaultDockerLabelPort:                             "abc",
+		DefaultDockerLabelPorts + "admin":                  "def",
+		DefaultDockerLabelHealth + HealthCheckLabelTimeout: "soon",
+	})
+	addService(api, "primary", "hostname", nil)
+	addService(api, "canary", "hostname", map[string]string{
+		DefaultDockerLabelCanaryOf: "primary",
+		DefaultDockerLabelWeight:   "101",
+	})
+
+	validations, err := r.ValidateServices()
+	assert.Nil(t, err)
+
+	if assert.Len(t, validations, 4) {
+		assert.Equal(t, "valid", validations[0].Name)
+		assert.True(t, validations[0].Valid(), "problems: %v", validations[0].Problems)
+
+		assert.Equal(t, "invalid", validations[1].Name)
+		assert.Len(t, validations[1].Problems, 3, "problems: %v", validations[1].Problems)
+
+		// the invalid weight of the canary affects the primary as well
+		assert.Equal(t, "primary", validations[2].Name)
+		assert.Len(t, validations[2].Problems, 1, "problems: %v", validations[2].Problems)
+
+		assert.Equal(t, "canary", validations[3].Name)
+		assert.Len(t, validations[3].Problems, 1, "problems: %v", validations[3].Problems)
+	}
+}
+
+func TestValidateServicesShouldReturnErrorWhenAPIFails(t *testing.T) {
+	r, api := setUp(t)
+	api.FailListServices = true
+
+	_, err := r.ValidateServices()
+	assert.NotNil(t, err)
+}
+
+func TestValidateServiceReportsMissingServices(t *testing.T) {
+	r, api := setUp(t)
+
+	assert.Len(t, r.ValidateService("service1"), 1)
+
+	api.Services["service1"] = &ecs.Service{TaskDefinition: aws.String("taskDef1")}
+	assert.Len(t, r.ValidateService("service1"), 1)
+
+	api.TaskDefs["taskDef1"] = &ecs.TaskDefinition{}
+	assert.Len(t, r.ValidateService("service1"), 1)
+}
+
+func TestValidateServiceValidatesAllDeployments(t *testing.T) {
+	r, api := setUp(t, WithDeploymentPolicy(DeploymentPolicyPrimaryWhenReady))
+	setUpDeployments(api, 2)
+
+	api.TaskDefs["taskDef1"].ContainerDefinitions[0].DockerLabels = aws.StringMap(map[string]string{
+		DefaultDockerLabelPort: "abc",
+	})
+
+	assert.Len(t, r.ValidateService("service1"), 1)
+}
+
+func TestValidateServicesWithContextShouldStopWhenCancelled(t *testing.T) {
+	r, api := setUp(t)
+	addService(api, "valid", "hostname", nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := r.ValidateServicesWithContext(ctx)
+	assert.Equal(t, context.Canceled, err)
+}
@@ -0,0 +1,489 @@
+// Copyright (c) 2017 off-sync
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package xds
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpoint "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	tls "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	upstreams "github.com/envoyproxy/go-control-plane/envoy/extensions/upstreams/http/v3"
+	matcher "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/off-sync/platform-proxy-aws/routing"
+	"github.com/off-sync/platform-proxy-aws/services"
+)
+
+// ConnectTimeout is the connect timeout of the clusters.
+const ConnectTimeout = 5 * time.Second
+
+// DefaultTrustedCA is the default file with the certificates of the CAs
+// trusted to sign the certificates of https upstreams.
+const DefaultTrustedCA = "/etc/ssl/certs/ca-certificates.crt"
+
+// Extension names used in the cluster configuration.
+const (
+	httpProtocolOptionsName = "envoy.extensions.upstreams.http.v3.HttpProtocolOptions"
+	tlsTransportSocketName  = "envoy.transport_sockets.tls"
+)
+
+// resources converts the routing table to xDS resources: clusters and their
+// load assignments for every route and a single route configuration with a
+// virtual host per route with host names. Routes with an error are left out.
+//
+// Backends are addressed by container host name, while EDS requires IP
+// addresses, so the host names are resolved using the provided function.
+func resources(table *routing.Table, routeConfigName, trustedCA string, resolve resolveFunc) (map[resource.Type][]types.Resource, error) {
+	var clusters, endpoints []types.Resource
+
+	routeConfig := &route.RouteConfiguration{Name: routeConfigName}
+
+	// Envoy rejects a route configuration with a domain in more than one
+	// virtual host
+	domains := make(map[string]bool)
+
+	for _, r := range table.Routes {
+		if r.Error != "" {
+			continue
+		}
+
+		rcs, err := routeClusters(r)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, rc := range rcs {
+			c, err := makeCluster(rc, trustedCA)
+			if err != nil {
+				return nil, err
+			}
+
+			cla, err := makeClusterLoadAssignment(rc, resolve)
+			if err != nil {
+				return nil, err
+			}
+
+			clusters = append(clusters, c)
+			endpoints = append(endpoints, cla)
+		}
+
+		if vh := makeVirtualHost(r, rcs, domains); vh != nil {
+			routeConfig.VirtualHosts = append(routeConfig.VirtualHosts, vh)
+		}
+	}
+
+	return map[resource.Type][]types.Resource{
+		resource.ClusterType:  clusters,
+		resource.EndpointType: endpoints,
+		resource.RouteType:    []types.Resource{routeConfig},
+	}, nil
+}
+
+// resolveFunc returns the IP addresses of a host name, or nil if it cannot be
+// resolved.
+type resolveFunc func(host string) []string
+
+// routeCluster is a cluster of a route, with the backends of the route that
+// share their transport settings.
+type routeCluster struct {
+	name     string
+	backends []*routing.Backend
+
+	// localityWeights holds the weight of the locality of every ECS service,
+	// weight their sum.
+	localityWeights map[string]uint32
+	weight          uint32
+}
+
+// transport holds the settings of a backend that must be the same for all
+// backends of a cluster.
+type transport struct {
+	scheme     string
+	protocol   services.Protocol
+	serverName string
+	skipVerify bool
+}
+
+// backendTransport returns the transport settings of a backend. The TLS
+// settings are only used for https backends.
+func backendTransport(backend *routing.Backend) (transport, error) {
+	u, err := url.Parse(backend.URL)
+	if err != nil {
+		return transport{}, err
+	}
+
+	t := transport{scheme: u.Scheme, protocol: backend.Protocol}
+
+	if u.Scheme == "https" {
+		t.serverName = backend.TLSServerName
+		if t.serverName == "" {
+			t.serverName = u.Hostname()
+		}
+
+		t.skipVerify = backend.TLSSkipVerify
+	}
+
+	return t, nil
+}
+
+// routeClusters splits the backends of the route with weight in clusters by
+// their transport settings, as Envoy configures these per cluster. A route
+// with a single cluster uses the name of its service for the cluster; the
+// clusters of other routes are numbered.
+//
+// Every server of a server set receives an equal share of the weight of the
+// set, so that the traffic is still divided by weight if a server set is
+// split. A route without backends with weight has a single empty cluster.
+func routeClusters(r *routing.Route) ([]*routeCluster, error) {
+	servers := make(map[string]int)
+
+	for _, backend := range r.Backends {
+		if backend.Weight > 0 {
+			servers[backend.Service]++
+		}
+	}
+
+	// scale the shares to integers
+	scale := 1
+	for _, n := range servers {
+		scale = scale / gcd(scale, n) * n
+	}
+
+	var rcs []*routeCluster
+
+	byTransport := make(map[transport]*routeCluster)
+
+	for _, backend := range r.Backends {
+		if backend.Weight < 1 {
+			continue
+		}
+
+		t, err := backendTransport(backend)
+		if err != nil {
+			return nil, err
+		}
+
+		rc, found := byTransport[t]
+		if !found {
+			rc = &routeCluster{localityWeights: make(map[string]uint32)}
+
+			byTransport[t] = rc
+			rcs = append(rcs, rc)
+		}
+
+		share := uint32(backend.Weight * scale / servers[backend.Service])
+
+		rc.backends = append(rc.backends, backend)
+		rc.localityWeights[backend.Service] += share
+		rc.weight += share
+	}
+
+	switch len(rcs) {
+	case 0:
+		return []*routeCluster{&routeCluster{name: r.Service}}, nil
+	case 1:
+		rcs[0].name = r.Service
+	default:
+		for i, rc := range rcs {
+			rc.name = fmt.Sprintf("%s/%d", r.Service, i+1)
+		}
+	}
+
+	return rcs, nil
+}
+
+// gcd returns the greatest common divisor of a and b.
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+
+	return a
+}
+
+// makeCluster returns an EDS cluster, which receives its endpoints over ADS,
+// with the transport settings of its backends.
+func makeCluster(rc *routeCluster, trustedCA string) (*cluster.Cluster, error) {
+	c := &cluster.Cluster{
+		Name:                 rc.name,
+		ClusterDiscoveryType: &cluster.Cluster_Type{Type: cluster.Cluster_EDS},
+		EdsClusterConfig: &cluster.Cluster_EdsClusterConfig{
+			EdsConfig: &core.ConfigSource{
+				ResourceApiVersion: core.ApiVersion_V3,
+				ConfigSourceSpecifier: &core.ConfigSource_Ads{
+					Ads: &core.AggregatedConfigSource{},
+				},
+			},
+		},
+		ConnectTimeout: durationpb.New(ConnectTimeout),
+		LbPolicy:       cluster.Cluster_ROUND_ROBIN,
+		CommonLbConfig: &cluster.Cluster_CommonLbConfig{
+			LocalityConfigSpecifier: &cluster.Cluster_CommonLbConfig_LocalityWeightedLbConfig_{
+				LocalityWeightedLbConfig: &cluster.Cluster_CommonLbConfig_LocalityWeightedLbConfig{},
+			},
+		},
+	}
+
+	if len(rc.backends) < 1 {
+		return c, nil
+	}
+
+	first := rc.backends[0]
+
+	if first.Protocol == services.ProtocolH2C || first.Protocol == services.ProtocolGRPC {
+		options, err := anypb.New(&upstreams.HttpProtocolOptions{
+			UpstreamProtocolOptions: &upstreams.HttpProtocolOptions_ExplicitHttpConfig_{
+				ExplicitHttpConfig: &upstreams.HttpProtocolOptions_ExplicitHttpConfig{
+					ProtocolConfig: &upstreams.HttpProtocolOptions_ExplicitHttpConfig_Http2ProtocolOptions{
+						Http2ProtocolOptions: &core.Http2ProtocolOptions{},
+					},
+				},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		c.TypedExtensionProtocolOptions = map[string]*anypb.Any{httpProtocolOptionsName: options}
+	}
+
+	u, err := url.Parse(first.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme == "https" {
+		tlsContext, err := anypb.New(makeUpstreamTLSContext(first, u, trustedCA))
+		if err != nil {
+			return nil, err
+		}
+
+		c.TransportSocket = &core.TransportSocket{
+			Name:       tlsTransportSocketName,
+			ConfigType: &core.TransportSocket_TypedConfig{TypedConfig: tlsContext},
+		}
+	}
+
+	return c, nil
+}
+
+// makeUpstreamTLSContext returns the TLS context of an https backend. The
+// server certificate must be signed by a trusted CA and have the TLS server
+// name, or the host name of the backend, as subject alternative name. Without
+// a validation context Envoy does not verify the server certificate, which is
+// only the case for backends with TLS skip verify.
+func makeUpstreamTLSContext(backend *routing.Backend, u *url.URL, trustedCA string) *tls.UpstreamTlsContext {
+	serverName := backend.TLSServerName
+	if serverName == "" {
+		serverName = u.Hostname()
+	}
+
+	tlsContext := &tls.UpstreamTlsContext{Sni: serverName}
+
+	if backend.TLSSkipVerify {
+		return tlsContext
+	}
+
+	tlsContext.CommonTlsContext = &tls.CommonTlsContext{
+		ValidationContextType: &tls.CommonTlsContext_ValidationContext{
+			ValidationContext: &tls.CertificateValidationContext{
+				TrustedCa: &core.DataSource{
+					Specifier: &core.DataSource_Filename{Filename: trustedCA},
+				},
+				MatchTypedSubjectAltNames: []*tls.SubjectAltNameMatcher{
+					&tls.SubjectAltNameMatcher{
+						SanType: tls.SubjectAltNameMatcher_DNS,
+						Matcher: &matcher.StringMatcher{
+							MatchPattern: &matcher.StringMatcher_Exact{Exact: serverName},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	return tlsContext
+}
+
+// makeClusterLoadAssignment returns the endpoints of the cluster, with a
+// locality per ECS service, weighted by the weight of the server set, so that
+// canaries receive their share of the traffic. A backend has an endpoint for
+// every IP address of its host. Backends without IP addresses are left out.
+func makeClusterLoadAssignment(rc *routeCluster, resolve resolveFunc) (*endpoint.ClusterLoadAssignment, error) {
+	cla := &endpoint.ClusterLoadAssignment{ClusterName: rc.name}
+
+	localities := make(map[string]*endpoint.LocalityLbEndpoints)
+
+	for _, backend := range rc.backends {
+		host, port, err := hostPort(backend.URL)
+		if err != nil {
+			return nil, err
+		}
+
+		addresses := []string{host}
+		if net.ParseIP(host) == nil {
+			addresses = resolve(host)
+		}
+
+		if len(addresses) < 1 {
+			continue
+		}
+
+		locality, found := localities[backend.Service]
+		if !found {
+			locality = &endpoint.LocalityLbEndpoints{
+				Locality:            &core.Locality{SubZone: backend.Service},
+				LoadBalancingWeight: wrapperspb.UInt32(rc.localityWeights[backend.Service]),
+			}
+
+			localities[backend.Service] = locality
+			cla.Endpoints = append(cla.Endpoints, locality)
+		}
+
+		for _, address := range addresses {
+			locality.LbEndpoints = append(locality.LbEndpoints, &endpoint.LbEndpoint{
+				HostIdentifier: &endpoint.LbEndpoint_Endpoint{
+					Endpoint: &endpoint.Endpoint{
+						Address:  socketAddress(address, port),
+						Hostname: host,
+					},
+				},
+			})
+		}
+	}
+
+	return cla, nil
+}
+
+// makeVirtualHost returns a virtual host routing all requests for the host
+// names of the route, on any port, to its clusters. Domains already in the
+// provided set are left out and the others are added to it. If no domains are
+// left, nil is returned.
+func makeVirtualHost(r *routing.Route, rcs []*routeCluster, domains map[string]bool) *route.VirtualHost {
+	var vhDomains []string
+
+	for _, host := range r.Hosts {
+		for _, domain := range []string{host, host + ":*"} {
+			if domains[domain] {
+				continue
+			}
+
+			domains[domain] = true
+			vhDomains = append(vhDomains, domain)
+		}
+	}
+
+	if len(vhDomains) < 1 {
+		return nil
+	}
+
+	return &route.VirtualHost{
+		Name:    r.Service,
+		Domains: vhDomains,
+		Routes: []*route.Route{
+			&route.Route{
+				Match: &route.RouteMatch{
+					PathSpecifier: &route.RouteMatch_Prefix{Prefix: "/"},
+				},
+				Action: &route.Route_Route{
+					Route: makeRouteAction(rcs),
+				},
+			},
+		},
+	}
+}
+
+// makeRouteAction returns a route action to the cluster of a route with a
+// single cluster, or to the weighted clusters of the route otherwise.
+func makeRouteAction(rcs []*routeCluster) *route.RouteAction {
+	if len(rcs) == 1 {
+		return &route.RouteAction{
+			ClusterSpecifier: &route.RouteAction_Cluster{Cluster: rcs[0].name},
+		}
+	}
+
+	weighted := &route.WeightedCluster{}
+
+	for _, rc := range rcs {
+		weighted.Clusters = append(weighted.Clusters, &route.WeightedCluster_ClusterWeight{
+			Name:   rc.name,
+			Weight: wrapperspb.UInt32(rc.weight),
+		})
+	}
+
+	return &route.RouteAction{
+		ClusterSpecifier: &route.RouteAction_WeightedClusters{WeightedClusters: weighted},
+	}
+}
+
+// hostPort returns the host and port of a backend URL, using the default
+// port of the scheme if the URL has no port.
+func hostPort(backendURL string) (string, uint32, error) {
+	u, err := url.Parse(backendURL)
+	if err != nil {
+		return "", 0, err
+	}
+
+	host, portString, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		host = u.Host
+
+		switch u.Scheme {
+		case "http":
+			portString = "80"
+		case "https":
+			portString = "443"
+		default:
+			return "", 0, fmt.Errorf("no port in backend URL: %s", backendURL)
+		}
+	}
+
+	port, err := strconv.ParseUint(portString, 10, 32)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port in backend URL: %s", backendURL)
+	}
+
+	return host, uint32(port), nil
+}
+
+// socketAddress returns the socket address of an IP address and port.
+func socketAddress(address string, port uint32) *core.Address {
+	return &core.Address{
+		Address: &core.Address_SocketAddress{
+			SocketAddress: &core.SocketAddress{
+				Address:       address,
+				PortSpecifier: &core.SocketAddress_PortValue{PortValue: port},
+			},
+		},
+	}
+}
@@ -0,0 +1,330 @@
+// Copyright (c) 2017 off-sync
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package xds implements an Envoy xDS management server, so that Envoy
+// proxies can route to the services discovered in ECS. Clusters, endpoints
+// and routes are served over gRPC and pushed to Envoy when they change.
+package xds
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	clusterservice "github.com/envoyproxy/go-control-plane/envoy/service/cluster/v3"
+	discoveryservice "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	endpointservice "github.com/envoyproxy/go-control-plane/envoy/service/endpoint/v3"
+	routeservice "github.com/envoyproxy/go-control-plane/envoy/service/route/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/off-sync/platform-proxy-aws/routing"
+)
+
+// Default values for the Server struct.
+const (
+	DefaultRefreshInterval = 30 * time.Second
+	DefaultRouteConfigName = "platform-proxy"
+)
+
+// ResolveTimeout is the timeout of resolving the host name of a backend.
+const ResolveTimeout = 5 * time.Second
+
+// Server is an xDS management server serving the routing table of a service
+// repository. The routing table is refreshed periodically and a new snapshot
+// is pushed to all connected proxies when it has changed.
+//
+// The host names of the backends are resolved on every refresh and served as
+// the endpoints of the clusters over EDS, so a changed IP address is pushed as
+// well. Only routes of services with host names get a virtual host.
+type Server struct {
+	repository      routing.ServiceRepository
+	refreshInterval time.Duration
+	routeConfigName string
+	trustedCA       string
+	errorHandler    func(error)
+	lookupHost      func(ctx context.Context, host string) ([]string, error)
+
+	cache      cache.SnapshotCache
+	grpcServer *grpc.Server
+
+	mutex    sync.Mutex
+	table    *routing.Table
+	served   map[resource.Type][]types.Resource
+	version  int
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// ServerOption defines the type used to further configure a Server.
+type ServerOption func(*Server) error
+
+// NewServer creates a new xDS management server for the provided service
+// repository.
+func NewServer(repository routing.ServiceRepository, options ...ServerOption) (*Server, error) {
+	s := &Server{
+		repository:      repository,
+		refreshInterval: DefaultRefreshInterval,
+		routeConfigName: DefaultRouteConfigName,
+		trustedCA:       DefaultTrustedCA,
+		errorHandler:    func(error) {},
+		lookupHost:      net.DefaultResolver.LookupHost,
+		cache:           cache.NewSnapshotCache(true, allNodes{}, nil),
+		grpcServer:      grpc.NewServer(),
+		done:            make(chan struct{}),
+	}
+
+	for _, opt := range options {
+		err := opt(s)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	xdsServer := server.NewServer(context.Background(), s.cache, nil)
+
+	discoveryservice.RegisterAggregatedDiscoveryServiceServer(s.grpcServer, xdsServer)
+	clusterservice.RegisterClusterDiscoveryServiceServer(s.grpcServer, xdsServer)
+	endpointservice.RegisterEndpointDiscoveryServiceServer(s.grpcServer, xdsServer)
+	routeservice.RegisterRouteDiscoveryServiceServer(s.grpcServer, xdsServer)
+
+	return s, nil
+}
+
+// WithRefreshInterval configures a server with the provided interval at which
+// the routing table is refreshed.
+func WithRefreshInterval(interval time.Duration) ServerOption {
+	return func(s *Server) error {
+		if interval <= 0 {
+			return fmt.Errorf("invalid refresh interval: %s", interval)
+		}
+
+		s.refreshInterval = interval
+		return nil
+	}
+}
+
+// WithRouteConfigName configures a server with the provided name of the route
+// configuration served over RDS.
+func WithRouteConfigName(name string) ServerOption {
+	return func(s *Server) error {
+		if name == "" {
+			return fmt.Errorf("invalid route config name: %s", name)
+		}
+
+		s.routeConfigName = name
+		return nil
+	}
+}
+
+// WithTrustedCA configures a server with the provided file with the
+// certificates of the CAs trusted to sign the certificates of https upstreams.
+// The file must be readable by the Envoy proxies.
+func WithTrustedCA(file string) ServerOption {
+	return func(s *Server) error {
+		if file == "" {
+			return fmt.Errorf("invalid trusted CA file: %s", file)
+		}
+
+		s.trustedCA = file
+		return nil
+	}
+}
+
+// WithErrorHandler configures a server with a handler that is called when a
+// periodic refresh fails, in which case the previous snapshot is served, or
+// when a service cannot be described or a backend cannot be resolved.
+func WithErrorHandler(handler func(error)) ServerOption {
+	return func(s *Server) error {
+		s.errorHandler = handler
+		return nil
+	}
+}
+
+// allNodes hashes all nodes to the same key, so that all proxies are served
+// the same snapshot.
+type allNodes struct{}
+
+// ID implements the cache.NodeHash interface.
+func (allNodes) ID(node *core.Node) string {
+	return ""
+}
+
+// Refresh builds the routing table and, if its resources have changed, pushes
+// a new snapshot to the connected proxies. If a service cannot be described, its
+// last known route is served, so that a temporary failure does not remove
+// its cluster. Services that have never been described are left out.
+func (s *Server) Refresh() error {
+	table, err := routing.BuildTable(s.repository)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.keepLastKnownRoutes(table)
+
+	res, err := resources(table, s.routeConfigName, s.trustedCA, s.resolver())
+	if err != nil {
+		return err
+	}
+
+	if s.served != nil && equalResources(res, s.served) {
+		s.table = table
+		return nil
+	}
+
+	snapshot, err := cache.NewSnapshot(strconv.Itoa(s.version+1), res)
+	if err != nil {
+		return err
+	}
+
+	err = s.cache.SetSnapshot(context.Background(), allNodes{}.ID(nil), snapshot)
+	if err != nil {
+		return err
+	}
+
+	s.table = table
+	s.served = res
+	s.version++
+
+	return nil
+}
+
+// keepLastKnownRoutes replaces the routes of the table with an error by the
+// routes served for the same services, if any.
+func (s *Server) keepLastKnownRoutes(table *routing.Table) {
+	for i, r := range table.Routes {
+		if r.Error == "" {
+			continue
+		}
+
+		s.errorHandler(fmt.Errorf("describing %s: %s", r.Service, r.Error))
+
+		if s.table == nil {
+			continue
+		}
+
+		if last := s.table.Route(r.Service); last != nil && last.Error == "" {
+			table.Routes[i] = last
+		}
+	}
+}
+
+// resolver returns a function resolving host names to their IP addresses,
+// in a stable order. Every host name is resolved once.
+func (s *Server) resolver() resolveFunc {
+	resolved := make(map[string][]string)
+
+	return func(host string) []string {
+		addresses, found := resolved[host]
+		if found {
+			return addresses
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), ResolveTimeout)
+		defer cancel()
+
+		addresses, err := s.lookupHost(ctx, host)
+		if err != nil {
+			s.errorHandler(fmt.Errorf("resolving %s: %s", host, err))
+		}
+
+		sort.Strings(addresses)
+		resolved[host] = addresses
+
+		return addresses
+	}
+}
+
+// equalResources returns whether both sets of resources are equal.
+func equalResources(a, b map[resource.Type][]types.Resource) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for typ, typed := range a {
+		other := b[typ]
+		if len(typed) != len(other) {
+			return false
+		}
+
+		for i := range typed {
+			if !proto.Equal(typed[i], other[i]) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// Version returns the version of the snapshot currently served, or zero if
+// no snapshot has been served yet.
+func (s *Server) Version() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.version
+}
+
+// Serve refreshes the routing table periodically and serves xDS requests on
+// the listener until Stop is called.
+func (s *Server) Serve(l net.Listener) error {
+	go s.run()
+
+	return s.grpcServer.Serve(l)
+}
+
+// Stop stops refreshing and closes all connections.
+func (s *Server) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.done)
+		s.grpcServer.Stop()
+	})
+}
+
+func (s *Server) run() {
+	ticker := time.NewTicker(s.refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		err := s.Refresh()
+		if err != nil {
+			s.errorHandler(err)
+		}
+
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,390 @@
+package xds
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpoint "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	tls "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	clusterservice "github.com/envoyproxy/go-control-plane/envoy/service/cluster/v3"
+	discoveryservice "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/off-sync/platform-proxy-aws/interfaces"
+	"github.com/off-sync/platform-proxy-aws/routing"
+	"github.com/off-sync/platform-proxy-aws/services"
+)
+
+func addService(api *interfaces.AwsEcsAPIMock, name, hostname string, labels map[string]string) {
+	api.ServiceNames = append(api.ServiceNames, name)
+
+	api.Services[name] = &ecs.Service{
+		ServiceName:    aws.String(name),
+		TaskDefinition: aws.String(name + "TaskDef"),
+	}
+
+	api.TaskDefs[name+"TaskDef"] = &ecs.TaskDefinition{
+		ContainerDefinitions: []*ecs.ContainerDefinition{
+			&ecs.ContainerDefinition{
+				DockerLabels: aws.StringMap(labels),
+				Name:         aws.String(services.DefaultServerContainerName),
+				Hostname:     aws.String(hostname),
+			},
+		},
+	}
+}
+
+func setUp(t *testing.T) (*services.ServiceRepository, *interfaces.AwsEcsAPIMock) {
+	api := interfaces.NewAwsEcsAPIMock()
+
+	r, err := services.NewServiceRepository(api, services.WithCanaryGrouping(true))
+	assert.Nil(t, err)
+
+	addService(api, "web", "web", map[string]string{
+		services.DefaultDockerLabelHosts: "www.example.com, Example.com",
+	})
+	addService(api, "web-canary", "canary", map[string]string{
+		services.DefaultDockerLabelCanaryOf: "web",
+		services.DefaultDockerLabelWeight:   "10",
+	})
+	addService(api, "api", "api", map[string]string{
+		services.DefaultDockerLabelPort: "abc",
+	})
+
+	return r, api
+}
+
+// addresses are the IP addresses of the host names of the test services.
+var addresses = map[string][]string{
+	"web":    []string{"10.0.0.2", "10.0.0.1"},
+	"canary": []string{"10.0.0.3"},
+	"admin":  []string{"10.0.0.4"},
+}
+
+func resolve(host string) []string {
+	return addresses[host]
+}
+
+func lookupHost(ctx context.Context, host string) ([]string, error) {
+	hostAddresses, found := addresses[host]
+	if !found {
+		return nil, fmt.Errorf("unknown host: %s", host)
+	}
+
+	return append([]string{}, hostAddresses...), nil
+}
+
+func newServer(t *testing.T, r routing.ServiceRepository, options ...ServerOption) *Server {
+	s, err := NewServer(r, options...)
+	assert.Nil(t, err)
+
+	s.lookupHost = lookupHost
+
+	return s
+}
+
+func TestResources(t *testing.T) {
+	r, _ := setUp(t)
+
+	table, err := routing.BuildTable(r)
+	assert.Nil(t, err)
+
+	res, err := resources(table, DefaultRouteConfigName, DefaultTrustedCA, resolve)
+	assert.Nil(t, err)
+
+	// the api service has an error and is left out
+	if assert.Len(t, res[resource.ClusterType], 1) {
+		c := res[resource.ClusterType][0].(*cluster.Cluster)
+		assert.Equal(t, "web", c.Name)
+		assert.Equal(t, cluster.Cluster_EDS, c.GetType())
+		assert.NotNil(t, c.EdsClusterConfig.EdsConfig.GetAds())
+		assert.Nil(t, c.LoadAssignment)
+	}
+
+	if assert.Len(t, res[resource.EndpointType], 1) {
+		cla := res[resource.EndpointType][0].(*endpoint.ClusterLoadAssignment)
+		assert.Equal(t, "web", cla.ClusterName)
+
+		if assert.Len(t, cla.Endpoints, 2) {
+			assert.Equal(t, "web", cla.Endpoints[0].Locality.SubZone)
+			assert.EqualValues(t, 90, cla.Endpoints[0].LoadBalancingWeight.Value)
+			assert.Len(t, cla.Endpoints[0].LbEndpoints, 2)
+			assert.Equal(t, "web-canary", cla.Endpoints[1].Locality.SubZone)
+			assert.EqualValues(t, 10, cla.Endpoints[1].LoadBalancingWeight.Value)
+
+			e := cla.Endpoints[1].LbEndpoints[0].GetEndpoint()
+			assert.Equal(t, "canary", e.Hostname)
+			assert.Equal(t, "10.0.0.3", e.Address.GetSocketAddress().Address)
+			assert.EqualValues(t, 8080, e.Address.GetSocketAddress().GetPortValue())
+		}
+	}
+
+	if assert.Len(t, res[resource.RouteType], 1) {
+		routeConfig := res[resource.RouteType][0].(*route.RouteConfiguration)
+
+		if assert.Len(t, routeConfig.VirtualHosts, 1) {
+			assert.EqualValues(t, []string{
+				"www.example.com", "www.example.com:*", "example.com", "example.com:*",
+			}, routeConfig.VirtualHosts[0].Domains)
+		}
+	}
+}
+
+func TestVirtualHostsShouldNotRepeatDomains(t *testing.T) {
+	domains := make(map[string]bool)
+
+	vh := makeVirtualHost(&routing.Route{Service: "web", Hosts: []string{"example.com"}}, nil, domains)
+	assert.EqualValues(t, []string{"example.com", "example.com:*"}, vh.Domains)
+
+	vh = makeVirtualHost(&routing.Route{Service: "web2", Hosts: []string{"example.com", "www.example.com"}}, nil, domains)
+	assert.EqualValues(t, []string{"www.example.com", "www.example.com:*"}, vh.Domains)
+
+	// a route without new domains has no virtual host
+	assert.Nil(t, makeVirtualHost(&routing.Route{Service: "web3", Hosts: []string{"example.com"}}, nil, domains))
+	assert.Nil(t, makeVirtualHost(&routing.Route{Service: "web4"}, nil, domains))
+}
+
+func TestResourcesTransport(t *testing.T) {
+	rcs, err := routeClusters(&routing.Route{
+		Service: "grpc",
+		Backends: []*routing.Backend{
+			&routing.Backend{
+				URL:           "https://grpc:9000",
+				Weight:        100,
+				Protocol:      services.ProtocolGRPC,
+				TLSServerName: "grpc.internal",
+			},
+		},
+	})
+	assert.Nil(t, err)
+	assert.Len(t, rcs, 1)
+
+	c, err := makeCluster(rcs[0], "/ca.pem")
+	assert.Nil(t, err)
+
+	assert.Equal(t, "grpc", c.Name)
+	assert.Contains(t, c.TypedExtensionProtocolOptions, httpProtocolOptionsName)
+	if assert.NotNil(t, c.TransportSocket) {
+		assert.Equal(t, tlsTransportSocketName, c.TransportSocket.Name)
+
+		tlsContext := &tls.UpstreamTlsContext{}
+		assert.Nil(t, c.TransportSocket.GetTypedConfig().UnmarshalTo(tlsContext))
+		assert.Equal(t, "grpc.internal", tlsContext.Sni)
+
+		validation := tlsContext.CommonTlsContext.GetValidationContext()
+		if assert.NotNil(t, validation) {
+			assert.Equal(t, "/ca.pem", validation.TrustedCa.GetFilename())
+			assert.Equal(t, "grpc.internal", validation.MatchTypedSubjectAltNames[0].Matcher.GetExact())
+		}
+	}
+}
+
+func TestResourcesShouldSplitMixedTransports(t *testing.T) {
+	table := &routing.Table{
+		Routes: []*routing.Route{
+			&routing.Route{
+				Service: "web",
+				Hosts:   []string{"example.com"},
+				Backends: []*routing.Backend{
+					&routing.Backend{URL: "http://10.0.0.1:8080", Weight: 90, Service: "web"},
+					&routing.Backend{URL: "https://10.0.0.2:8443", Weight: 90, Service: "web"},
+					&routing.Backend{URL: "https://10.0.0.3:8443", Weight: 10, Service: "web-canary", TLSServerName: "10.0.0.2"},
+					&routing.Backend{URL: "http://10.0.0.4:8080", Weight: 10, Protocol: services.ProtocolH2C, Service: "web-canary"},
+				},
+			},
+		},
+	}
+
+	res, err := resources(table, DefaultRouteConfigName, DefaultTrustedCA, resolve)
+	assert.Nil(t, err)
+
+	if assert.Len(t, res[resource.ClusterType], 3) {
+		for i, name := range []string{"web/1", "web/2", "web/3"} {
+			assert.Equal(t, name, res[resource.ClusterType][i].(*cluster.Cluster).Name)
+		}
+
+		assert.Nil(t, res[resource.ClusterType][0].(*cluster.Cluster).TransportSocket)
+		assert.NotNil(t, res[resource.ClusterType][1].(*cluster.Cluster).TransportSocket)
+		assert.Contains(t, res[resource.ClusterType][2].(*cluster.Cluster).TypedExtensionProtocolOptions, httpProtocolOptionsName)
+	}
+
+	// the https backends have the same server name and share a cluster, with
+	// a locality per ECS service
+	if assert.Len(t, res[resource.EndpointType], 3) {
+		cla := res[resource.EndpointType][1].(*endpoint.ClusterLoadAssignment)
+		assert.Equal(t, "web/2", cla.ClusterName)
+
+		if assert.Len(t, cla.Endpoints, 2) {
+			assert.EqualValues(t, 90, cla.Endpoints[0].LoadBalancingWeight.Value)
+			assert.EqualValues(t, 10, cla.Endpoints[1].LoadBalancingWeight.Value)
+		}
+	}
+
+	// every server receives an equal share of the weight of its server set,
+	// scaled by the number of servers of the sets
+	routeConfig := res[resource.RouteType][0].(*route.RouteConfiguration)
+	if assert.Len(t, routeConfig.VirtualHosts, 1) {
+		weighted := routeConfig.VirtualHosts[0].Routes[0].GetRoute().GetWeightedClusters()
+		if assert.NotNil(t, weighted) && assert.Len(t, weighted.Clusters, 3) {
+			for i, weight := range []uint32{90, 100, 10} {
+				assert.Equal(t, weight, weighted.Clusters[i].Weight.Value)
+			}
+		}
+	}
+}
+
+func TestUpstreamTLSContextWithSkipVerify(t *testing.T) {
+	backend := &routing.Backend{URL: "https://web:8443", TLSSkipVerify: true}
+
+	u, err := url.Parse(backend.URL)
+	assert.Nil(t, err)
+
+	tlsContext := makeUpstreamTLSContext(backend, u, DefaultTrustedCA)
+	assert.Equal(t, "web", tlsContext.Sni)
+	assert.Nil(t, tlsContext.CommonTlsContext)
+}
+
+func TestServerShouldPushClustersOverGRPC(t *testing.T) {
+	r, api := setUp(t)
+
+	s := newServer(t, r, WithRefreshInterval(time.Hour))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	go s.Serve(l)
+	defer s.Stop()
+
+	conn, err := grpc.NewClient(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stream, err := clusterservice.NewClusterDiscoveryServiceClient(conn).StreamClusters(ctx)
+	assert.Nil(t, err)
+
+	node := &core.Node{Id: "test"}
+
+	err = stream.Send(&discoveryservice.DiscoveryRequest{Node: node, TypeUrl: resource.ClusterType})
+	assert.Nil(t, err)
+
+	resp, err := stream.Recv()
+	assert.Nil(t, err)
+	assert.Equal(t, "1", resp.VersionInfo)
+	assert.Len(t, resp.Resources, 1)
+
+	// acknowledge the response and wait for the next push
+	err = stream.Send(&discoveryservice.DiscoveryRequest{
+		Node:          node,
+		TypeUrl:       resource.ClusterType,
+		VersionInfo:   resp.VersionInfo,
+		ResponseNonce: resp.Nonce,
+	})
+	assert.Nil(t, err)
+
+	addService(api, "admin", "admin", nil)
+	assert.Nil(t, s.Refresh())
+
+	resp, err = stream.Recv()
+	assert.Nil(t, err)
+	assert.Equal(t, "2", resp.VersionInfo)
+	assert.Len(t, resp.Resources, 2)
+}
+
+func TestRefreshShouldKeepVersionWhenUnchanged(t *testing.T) {
+	r, api := setUp(t)
+
+	s := newServer(t, r)
+
+	assert.Nil(t, s.Refresh())
+	assert.Nil(t, s.Refresh())
+	assert.Equal(t, 1, s.Version())
+
+	api.FailListServices = true
+	assert.NotNil(t, s.Refresh())
+	assert.Equal(t, 1, s.Version())
+}
+
+func TestRefreshShouldKeepRoutesOfServicesThatFailToDescribe(t *testing.T) {
+	r, api := setUp(t)
+
+	var errs []error
+
+	s := newServer(t, r, WithErrorHandler(func(err error) {
+		errs = append(errs, err)
+	}))
+
+	assert.Nil(t, s.Refresh())
+	assert.Equal(t, 1, s.Version())
+
+	// the api service has an invalid port
+	assert.Len(t, errs, 1)
+
+	api.TaskDefs["webTaskDef"].ContainerDefinitions[0].DockerLabels[services.DefaultDockerLabelPort] = aws.String("abc")
+	assert.Nil(t, s.Refresh())
+
+	// the web route is kept, so the snapshot is unchanged
+	assert.Equal(t, 1, s.Version())
+	assert.Len(t, errs, 3)
+
+	snapshot, err := s.cache.GetSnapshot(allNodes{}.ID(nil))
+	assert.Nil(t, err)
+	assert.Len(t, snapshot.GetResources(resource.ClusterType), 1)
+}
+
+func TestRefreshShouldPushChangedAddresses(t *testing.T) {
+	r, _ := setUp(t)
+
+	var errs []error
+
+	s := newServer(t, r, WithErrorHandler(func(err error) {
+		errs = append(errs, err)
+	}))
+
+	resolved := []string{"10.0.0.1"}
+	s.lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		if host == "canary" {
+			return nil, fmt.Errorf("unknown host: %s", host)
+		}
+
+		return resolved, nil
+	}
+
+	assert.Nil(t, s.Refresh())
+	assert.Equal(t, 1, s.Version())
+
+	// the api service has an invalid port and the canary cannot be resolved
+	assert.Len(t, errs, 2)
+
+	snapshot, err := s.cache.GetSnapshot(allNodes{}.ID(nil))
+	assert.Nil(t, err)
+
+	cla := snapshot.GetResources(resource.EndpointType)["web"].(*endpoint.ClusterLoadAssignment)
+	assert.Len(t, cla.Endpoints, 1)
+
+	resolved = []string{"10.0.0.2"}
+	assert.Nil(t, s.Refresh())
+	assert.Equal(t, 2, s.Version())
+}
+
+func TestInvalidOptions(t *testing.T) {
+	_, err := NewServer(nil, WithRefreshInterval(0))
+	assert.NotNil(t, err)
+
+	_, err = NewServer(nil, WithRouteConfigName(""))
+	assert.NotNil(t, err)
+}